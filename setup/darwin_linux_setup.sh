@@ -39,6 +39,7 @@ ollama pull qwen2.5:0.5b
 ollama pull llama3.2:1b
 ollama pull llama3.2:3b
 ollama pull phi3:3.8b
+ollama pull all-minilm:33m
 
 if [ $? -ne 0 ]; then
     echo "Failed to pull one or more models. Check the error messages above."
@@ -4,9 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net/http"
-	"net/url"
-	"os"
 	"strings"
 
 	"github.com/ollama/ollama/api"
@@ -28,39 +25,37 @@ func getOllamaModelName() string {
 	return ollamaModelName
 }
 
-// Define system content and options for the query
-const systemInstructions = `You are a helpful assistant by the name of PaperPal.
-Your purpose is to assist users with questions, mostly related to paper and automation.
-You were created by the Finnish company Valmet, a lead developer and supplier of process 
-technologies, automation systems and services for the pulp, paper, energy industries.
+var embeddingModelName = "all-minilm:33m"
 
-You should be friendly and helpful to the users. All answers should be based on the information from the documents, 
-unless otherwise specified or inferred. Documents will appear as previous messages in the 
-conversation - you can refer to them directly if needed. You should not make up any information. If you don't 
-know the answer, you should say so. Do not hallucinate.
-
-If queried about a topic without the needed to refer to the documents, you should answer based on your training data.
-Make these answers as helpful as possible - and try to relate the reply back to Valmet (for paper and automation only).
-`
+func setEmbeddingModelName(modelName string) {
+	embeddingModelName = modelName
+}
 
-func talkToOllama(userQuestion string) (string, error) {
-	ctx := context.Background()
+func getEmbeddingModelName() string {
+	return embeddingModelName
+}
 
-	// Set the Ollama host
-	ollamaRawUrl := os.Getenv("OLLAMA_HOST")
-	if ollamaRawUrl == "" {
-		ollamaRawUrl = "http://localhost:11434"
+// interruptedMarker is appended to answers that were stopped before the model finished.
+const interruptedMarker = "\n\n[Answer interrupted]"
+
+// talkToOllama asks the selected model the user's question, together with the
+// document chunks retrieved for it and the earlier turns of the conversation,
+// and returns the model's answer. Each token of the answer is passed to onToken
+// as it arrives. The question and answer are added to the conversation.
+//
+// If ctx is cancelled while the answer is generated, the partial answer is
+// returned marked as interrupted for display, together with the context's
+// error. The conversation keeps the partial answer without the marker.
+func talkToOllama(ctx context.Context, chat *conversation, userQuestion string, documentChunks []indexedChunk, onToken func(token string)) (string, error) {
+	client, err := newOllamaClient()
+	if err != nil {
+		return "", err
 	}
 
-	parsedUrl, _ := url.Parse(ollamaRawUrl)
-	client := api.NewClient(parsedUrl, http.DefaultClient)
-
-	// Combine the user question and document content into a single query
-
-	// Prepare the messages for the API request
-	messages := []api.Message{
-		{Role: "system", Content: systemInstructions},
-		{Role: "user", Content: userQuestion},
+	// Combine the conversation, user question and document content into the messages for the API request
+	messages, err := buildMessages(systemInstructions, chat.history(), userQuestion, documentChunks)
+	if err != nil {
+		return "", err
 	}
 
 	// Configure the chat request
@@ -80,34 +75,76 @@ func talkToOllama(userQuestion string) (string, error) {
 	// Capture response
 	responseBuilder := &strings.Builder{}
 
-	err := client.Chat(ctx, req, func(resp api.ChatResponse) error {
-		fmt.Print(resp.Message.Content)
+	err = client.Chat(ctx, req, func(resp api.ChatResponse) error {
 		responseBuilder.WriteString(resp.Message.Content)
+		if onToken != nil {
+			onToken(resp.Message.Content)
+		}
 		return nil
 	})
 
+	// Keep what was generated before the request was cancelled
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		log.Printf("Chat request stopped: %v\n", ctxErr)
+		// The marker is only shown to the user, the model sees the partial answer as it was
+		chat.addTurn(userQuestion, responseBuilder.String())
+		return responseBuilder.String() + interruptedMarker, ctxErr
+	}
+
 	// Handle errors gracefully
 	if err != nil {
 		log.Printf("Error during chat request: %v\n", err)
 		return "", err
 	}
 
-	// Remove the temporary file
-	if err := deleteTempFile(); err != nil {
-		log.Printf("Error deleting temporary file: %v\n", err)
-	}
+	// Remember the turn for follow-up questions
+	chat.addTurn(userQuestion, responseBuilder.String())
 
 	// Return the response
 	return responseBuilder.String(), nil
 }
 
+// embedTexts converts the given texts into embedding vectors using the selected
+// embedding model. Texts are sent in batches to keep each request small.
+func embedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	const batchSize = 32
+
+	client, err := newOllamaClient()
+	if err != nil {
+		return nil, err
+	}
+	embeddings := make([][]float32, 0, len(texts))
+
+	for start := 0; start < len(texts); start += batchSize {
+		end := min(start+batchSize, len(texts))
+
+		resp, err := client.Embed(ctx, &api.EmbedRequest{
+			Model:    getEmbeddingModelName(),
+			Input:    texts[start:end],
+			Truncate: &TRUE,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed texts with %s: %w", getEmbeddingModelName(), err)
+		}
+		if len(resp.Embeddings) != end-start {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", end-start, len(resp.Embeddings))
+		}
+
+		embeddings = append(embeddings, resp.Embeddings...)
+	}
+
+	return embeddings, nil
+}
+
 // NOTE: Uncomment the main function to run the API standalone
 // func main() {
 // 	// Example usage
 // 	documentContent := "This is the document content that will be used in the query."
 // 	userQuestion := "What does this document say about automation in paper industries?"
 
-// 	response, err := talkToOllama(userQuestion, documentContent)
+// 	response, err := talkToOllama(context.Background(), newConversation(historyTokenBudget), userQuestion, []indexedChunk{{Text: documentContent}}, func(token string) {
+// 		fmt.Print(token)
+// 	})
 // 	if err != nil {
 // 		log.Fatalf("Error communicating with Ollama: %v", err)
 // 	}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+// useFakeOllama points the Ollama client at a test server for the duration of a test.
+func useFakeOllama(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	previous := getOllamaConnection()
+	connection := defaultOllamaConnection()
+	connection.Endpoint = server.URL
+	setOllamaConnection(connection)
+	t.Cleanup(func() { setOllamaConnection(previous) })
+}
+
+func TestInterruptedAnswerIsStoredWithoutMarker(t *testing.T) {
+	useFakeOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		// Stream part of an answer, then wait until the client gives up
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"model":"test","message":{"role":"assistant","content":"Partial answer"},"done":false}` + "\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	chat := newConversation(historyTokenBudget)
+	answer, err := talkToOllama(ctx, chat, "Question?", nil, func(token string) {
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+
+	// The user sees that the answer was interrupted, the model does not
+	if answer != "Partial answer"+interruptedMarker {
+		t.Errorf("answer = %q", answer)
+	}
+	history := chat.history()
+	if len(history) != 2 || history[1].Content != "Partial answer" {
+		t.Fatalf("history = %v", history)
+	}
+	if strings.Contains(history[1].Content, strings.TrimSpace(interruptedMarker)) {
+		t.Errorf("history contains the interrupted marker: %q", history[1].Content)
+	}
+}
+
+func TestAnswerIsStreamedAndStored(t *testing.T) {
+	chunks := []string{"Felt", " tension", " is 4.5", " kN/m."}
+	useFakeOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		var req api.ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.URL.Path != "/api/chat" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if req.Stream == nil || !*req.Stream {
+			http.Error(w, "request is not streamed", http.StatusBadRequest)
+			return
+		}
+
+		// Send each chunk on its own, the last one ending the answer
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for i, chunk := range chunks {
+			json.NewEncoder(w).Encode(api.ChatResponse{
+				Model:   req.Model,
+				Message: api.Message{Role: "assistant", Content: chunk},
+				Done:    i == len(chunks)-1,
+			})
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	chat := newConversation(historyTokenBudget)
+	var tokens []string
+	answer, err := talkToOllama(context.Background(), chat, "What is the felt tension?", nil, func(token string) {
+		tokens = append(tokens, token)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(tokens, chunks) {
+		t.Errorf("tokens = %q, want %q", tokens, chunks)
+	}
+	if answer != "Felt tension is 4.5 kN/m." {
+		t.Errorf("answer = %q", answer)
+	}
+	history := chat.history()
+	if len(history) != 2 || history[0].Content != "What is the felt tension?" || history[1].Content != answer {
+		t.Errorf("history = %v", history)
+	}
+}
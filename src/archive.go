@@ -0,0 +1,393 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveSeparator separates the path of an archive from the path of an entry
+// inside it, e.g. "pkg.zip!/docs/manual.pdf".
+const archiveSeparator = "!/"
+
+// Guards against archives that expand to far more data than they take on disk
+const (
+	maxArchiveEntries   = 10000
+	maxArchiveExpansion = 2 * 1000 * 1000 * 1000 // Bytes extracted from a single archive
+	maxCompressionRatio = 100                    // Uncompressed size of a zip entry relative to its compressed size
+)
+
+// archiveEntry is a file inside an archive.
+type archiveEntry struct {
+	Name    string // Slash separated path inside the archive
+	ModTime time.Time
+	Size    int64
+	err     error // Why the entry is not extracted, e.g. a suspicious compression ratio
+}
+
+// isArchive reports whether a file is an archive whose entries are indexed.
+func isArchive(filePath string) bool {
+	name := strings.ToLower(filePath)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveEntryPath returns the path of an entry inside an archive.
+func archiveEntryPath(archivePath, entry string) string {
+	return archivePath + archiveSeparator + entry
+}
+
+// splitArchivePath splits the path of an archive entry into the path of the
+// archive and of the entry. It returns false for the paths of regular files.
+func splitArchivePath(filePath string) (archivePath, entry string, ok bool) {
+	offset := 0
+	for {
+		i := strings.Index(filePath[offset:], archiveSeparator)
+		if i < 0 {
+			return "", "", false
+		}
+		i += offset
+		if isArchive(filePath[:i]) {
+			return filePath[:i], filePath[i+len(archiveSeparator):], true
+		}
+		offset = i + len(archiveSeparator)
+	}
+}
+
+// listArchiveEntries returns the files inside an archive, without reading
+// them. Entries that look like a zip bomb are returned with an error. Listing
+// stops with an error once the entries expand to more than
+// maxArchiveExpansion, returning the entries listed until then.
+func listArchiveEntries(archivePath string) ([]archiveEntry, error) {
+	var entries []archiveEntry
+	var total int64
+	add := func(name string, modTime time.Time, size int64, err error) error {
+		name, ok := archiveEntryName(name)
+		if !ok {
+			return nil
+		}
+		if len(entries) >= maxArchiveEntries {
+			return fmt.Errorf("archive has more than %d entries", maxArchiveEntries)
+		}
+		if err == nil {
+			if total+size > maxArchiveExpansion {
+				return fmt.Errorf("archive expands to more than %s", formatBytes(maxArchiveExpansion))
+			}
+			total += size
+		}
+		entries = append(entries, archiveEntry{Name: name, ModTime: modTime, Size: size, err: err})
+		return nil
+	}
+
+	if isZipArchive(archivePath) {
+		archive, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+		}
+		defer archive.Close()
+
+		for _, file := range archive.File {
+			if file.FileInfo().IsDir() {
+				continue
+			}
+			if err := add(file.Name, file.Modified, int64(file.UncompressedSize64), checkZipEntry(file)); err != nil {
+				return entries, err
+			}
+		}
+		return entries, nil
+	}
+
+	err := walkTarArchive(archivePath, func(header *tar.Header, r io.Reader) (bool, error) {
+		return true, add(header.Name, header.ModTime, header.Size, nil)
+	})
+	return entries, err
+}
+
+// isZipArchive reports whether an archive is a zip file rather than a tar file.
+func isZipArchive(archivePath string) bool {
+	return strings.HasSuffix(strings.ToLower(archivePath), ".zip")
+}
+
+// checkZipEntry returns an error for zip entries that expand suspiciously
+// much, which is typical for zip bombs.
+func checkZipEntry(file *zip.File) error {
+	if file.UncompressedSize64 > maxCompressionRatio*max(file.CompressedSize64, 1024) {
+		return fmt.Errorf("compression ratio above %d:1", maxCompressionRatio)
+	}
+	return nil
+}
+
+// archiveEntryName returns the cleaned path of an archive entry, or false
+// for entries with an absolute path or a path leading out of the archive.
+func archiveEntryName(name string) (string, bool) {
+	name = path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == ".." || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	return name, true
+}
+
+// walkTarArchive calls fn for each regular file in a tar archive, which may be
+// gzip compressed, until fn returns false or an error.
+func walkTarArchive(archivePath string, fn func(header *tar.Header, r io.Reader) (bool, error)) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if name := strings.ToLower(archivePath); strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to decompress archive %s: %w", archivePath, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read archive %s: %w", archivePath, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		next, err := fn(header, reader)
+		if err != nil || !next {
+			return err
+		}
+	}
+}
+
+// readArchiveEntry reads a file inside an archive into memory.
+func readArchiveEntry(archivePath, entry string) ([]byte, error) {
+	if isZipArchive(archivePath) {
+		archive, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+		}
+		defer archive.Close()
+
+		for _, file := range archive.File {
+			if name, ok := archiveEntryName(file.Name); !ok || name != entry || file.FileInfo().IsDir() {
+				continue
+			}
+			if err := checkZipEntry(file); err != nil {
+				return nil, fmt.Errorf("%s in archive %s: %w", entry, archivePath, err)
+			}
+			r, err := file.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to open %s in archive %s: %w", entry, archivePath, err)
+			}
+			defer r.Close()
+			return readArchiveData(r, int64(file.UncompressedSize64), archivePath, entry)
+		}
+		return nil, fmt.Errorf("%s not found in archive %s", entry, archivePath)
+	}
+
+	// Tar archives can only be read from the start, so the archive is read up to the entry
+	var data []byte
+	found := false
+	err := walkTarArchive(archivePath, func(header *tar.Header, r io.Reader) (bool, error) {
+		if name, ok := archiveEntryName(header.Name); !ok || name != entry {
+			return true, nil
+		}
+		found = true
+		var err error
+		data, err = readArchiveData(r, header.Size, archivePath, entry)
+		return false, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%s not found in archive %s", entry, archivePath)
+	}
+	return data, nil
+}
+
+// readArchiveData reads the contents of an archive entry. No more than the
+// size recorded in the archive is read, and entries larger than the file size
+// limit are refused, so a malicious archive can not exhaust the memory.
+func readArchiveData(r io.Reader, size int64, archivePath, entry string) ([]byte, error) {
+	if limit := getIndexLimits().MaxFileSize; (limit > 0 && size > limit) || size > maxArchiveExpansion {
+		return nil, fmt.Errorf("%s in archive %s is too large to extract", entry, archivePath)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, size+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from archive %s: %w", entry, archivePath, err)
+	}
+	if int64(len(data)) > size {
+		return nil, fmt.Errorf("%s in archive %s is larger than its recorded size", entry, archivePath)
+	}
+	return data, nil
+}
+
+// tarEntry is an entry read ahead from a tar archive, or why it could not be read.
+type tarEntry struct {
+	data []byte
+	err  error
+}
+
+var (
+	tarEntries      = map[string]tarEntry{} // Keyed by the path of the entry, e.g. "a.tar!/b.pdf"
+	tarEntriesMutex sync.Mutex
+)
+
+// readTarArchive reads the given entries of a tar archive in a single pass.
+// Tar archives can only be read from the start, so looking up every entry on
+// its own would read the archive again for each of them. Each entry is kept in
+// memory until forgetTarEntry is called, so it is hashed and extracted without
+// reading the archive again. fn is called for every entry once it is read, or
+// could not be read.
+func readTarArchive(archivePath string, entries []string, fn func(entry string)) {
+	wanted := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		wanted[entry] = true
+	}
+
+	keep := func(entry string, data []byte, err error) {
+		tarEntriesMutex.Lock()
+		defer tarEntriesMutex.Unlock()
+		tarEntries[archiveEntryPath(archivePath, entry)] = tarEntry{data: data, err: err}
+	}
+
+	err := walkTarArchive(archivePath, func(header *tar.Header, r io.Reader) (bool, error) {
+		name, ok := archiveEntryName(header.Name)
+		if !ok || !wanted[name] {
+			return true, nil
+		}
+		delete(wanted, name)
+
+		data, err := readArchiveData(r, header.Size, archivePath, name)
+		keep(name, data, err)
+		fn(name)
+		return len(wanted) > 0, nil
+	})
+
+	// Entries that were not reached fail with the error of the archive
+	for _, entry := range entries {
+		if !wanted[entry] {
+			continue
+		}
+		if err == nil {
+			err = fmt.Errorf("%s not found in archive %s", entry, archivePath)
+		}
+		keep(entry, nil, err)
+		fn(entry)
+	}
+}
+
+// forgetTarEntry frees an entry kept by readTarArchive.
+func forgetTarEntry(filePath string) {
+	tarEntriesMutex.Lock()
+	defer tarEntriesMutex.Unlock()
+	delete(tarEntries, filePath)
+}
+
+// sourceFile is an opened file to extract text from. Files inside archives
+// are read into memory.
+type sourceFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// diskFile is a sourceFile on disk.
+type diskFile struct {
+	*os.File
+	size int64
+}
+
+func (f diskFile) Size() int64 { return f.size }
+
+// memoryFile is a sourceFile read from an archive.
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error { return nil }
+
+// openSourceFile opens a file in the selected folder, or an entry of an
+// archive in the folder when the path points into an archive.
+func openSourceFile(filePath string) (sourceFile, error) {
+	if archivePath, entry, ok := splitArchivePath(filePath); ok {
+		tarEntriesMutex.Lock()
+		read, found := tarEntries[filePath]
+		tarEntriesMutex.Unlock()
+		if found {
+			// Read ahead by readTarArchive
+			if read.err != nil {
+				return nil, read.err
+			}
+			return memoryFile{bytes.NewReader(read.data)}, nil
+		}
+
+		data, err := readArchiveEntry(archivePath, entry)
+		if err != nil {
+			return nil, err
+		}
+		return memoryFile{bytes.NewReader(data)}, nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read file info of %s: %w", filePath, err)
+	}
+	return diskFile{File: file, size: info.Size()}, nil
+}
+
+// openZipFile opens a zip based document such as a Word document, which may
+// itself be inside an archive. Reading its entries fails once the context is
+// canceled.
+func openZipFile(ctx context.Context, filePath string) (*zip.Reader, io.Closer, error) {
+	file, err := openSourceFile(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	archive, err := zip.NewReader(cancelableReaderAt{ctx: ctx, r: file}, file.Size())
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return archive, file, nil
+}
+
+// cancelableReaderAt fails every read once its context is canceled, so that
+// libraries parsing a file, such as the PDF reader, stop when the extraction
+// times out.
+type cancelableReaderAt struct {
+	ctx context.Context
+	r   io.ReaderAt
+}
+
+func (c cancelableReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.ReadAt(p, off)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTarGz writes a gzip compressed tar archive holding the given files.
+func writeTarGz(t *testing.T, archivePath string, files map[string]string) {
+	t.Helper()
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name])), ModTime: time.Now(), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeZip writes a zip archive holding the given files.
+func writeZip(t *testing.T, archivePath string, files map[string]string) {
+	t.Helper()
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zw := zip.NewWriter(file)
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchivePatternsMatchTheFolderRelativePath(t *testing.T) {
+	folder := writeTestFiles(t, map[string]string{"docs/notes.txt": "Loose notes"})
+	entries := map[string]string{
+		"notes.txt":      "Felt tension",
+		"drafts/old.txt": "Draft",
+		"readme.md":      "# Readme",
+	}
+	writeZip(t, filepath.Join(folder, "docs", "pkg.zip"), entries)
+	writeTarGz(t, filepath.Join(folder, "docs", "pkg.tar.gz"), entries)
+
+	options := folderOptions{
+		Include: []string{"docs/**/*.txt"},
+		Exclude: []string{"docs/pkg.zip!/drafts/", "docs/pkg.tar.gz!/drafts/"},
+	}
+	listing, err := listFolderFiles(folder, options, defaultIndexLimits())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, file := range listing.Files {
+		paths = append(paths, file.RelPath)
+	}
+	want := []string{"docs/notes.txt", "docs/pkg.tar.gz!/notes.txt", "docs/pkg.zip!/notes.txt"}
+	if !slices.Equal(paths, want) {
+		t.Errorf("files = %v, want %v", paths, want)
+	}
+	if listing.Ignored != 4 {
+		t.Errorf("%d ignored, want the drafts and readme of both archives", listing.Ignored)
+	}
+}
+
+func TestListArchiveEntriesStopsAtExpansionLimit(t *testing.T) {
+	// Entries whose headers claim more data than the limit, without holding it
+	archivePath := filepath.Join(t.TempDir(), "bomb.zip")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(file)
+	size := uint64(maxArchiveExpansion/2 + 1)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, UncompressedSize64: size, CompressedSize64: size / 10}
+		if _, err := zw.CreateRaw(header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	entries, err := listArchiveEntries(archivePath)
+	if err == nil || !strings.Contains(err.Error(), "expands to more than") {
+		t.Fatalf("err = %v, want the expansion limit", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "a.txt" {
+		t.Errorf("entries = %+v, want only the first entry", entries)
+	}
+}
+
+func TestTarArchiveIsReadOnce(t *testing.T) {
+	folder := t.TempDir()
+	archivePath := filepath.Join(folder, "manuals.tar.gz")
+	writeTarGz(t, archivePath, map[string]string{
+		"a.txt": "Felt tension is 4.5 kN/m.",
+		"b.txt": "Nip load is 80 kN/m.",
+		"c.txt": "Dryer steam is 3 bar.",
+	})
+	limits := defaultIndexLimits()
+	limits.Workers = 2
+
+	var jobs []extractJob
+	for _, name := range []string{"c.txt", "a.txt", "b.txt"} {
+		jobs = append(jobs, extractJob{file: folderFile{Path: archiveEntryPath(archivePath, name), RelPath: archiveEntryPath("manuals.tar.gz", name)}})
+	}
+	results := extractFiles(context.Background(), jobs, folderOptions{}, limits)
+	for i, result := range results {
+		if result.err != nil || result.doc.Hash == "" || len(result.doc.Sections) != 1 {
+			t.Fatalf("%s: %+v", jobs[i].file.RelPath, result)
+		}
+	}
+	if text := results[0].doc.Sections[0].Text; !strings.Contains(text, "Dryer steam") {
+		t.Errorf("c.txt = %q", text)
+	}
+
+	// Entries are hashed and extracted from the single pass, even once the archive is gone
+	moved := archivePath + ".moved"
+	read := 0
+	readTarArchive(archivePath, []string{"a.txt", "b.txt", "missing.txt"}, func(entry string) {
+		if read == 0 {
+			if err := os.Rename(archivePath, moved); err != nil {
+				t.Skip("the archive can not be moved while it is open:", err)
+			}
+		}
+		read++
+
+		entryPath := archiveEntryPath(archivePath, entry)
+		defer forgetTarEntry(entryPath)
+		_, err := hashFile(entryPath)
+		if entry == "missing.txt" {
+			if err == nil {
+				t.Error("missing.txt was found")
+			}
+			return
+		}
+		if err != nil {
+			t.Errorf("%s: %v", entry, err)
+		}
+	})
+	if read != 3 {
+		t.Errorf("fn was called for %d entries, want 3", read)
+	}
+	if len(tarEntries) != 0 {
+		t.Errorf("%d entries are still kept in memory", len(tarEntries))
+	}
+}
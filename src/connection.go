@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// defaultOllamaEndpoint is used when neither the settings nor OLLAMA_HOST name a server.
+const defaultOllamaEndpoint = "http://localhost:11434"
+
+// ollamaConnection holds the settings used to reach the Ollama server, which
+// may sit behind a reverse proxy that requires authentication.
+type ollamaConnection struct {
+	Endpoint    string        // Base URL of the Ollama server
+	Timeout     time.Duration // How long to wait for the server to start responding
+	BearerToken string        // Optional token sent in the Authorization header
+	Headers     http.Header   // Optional extra headers sent with every request
+}
+
+var (
+	ollamaSettings      = defaultOllamaConnection()
+	ollamaHTTPClient    *http.Client // Shared by all requests to ollamaSettings, created on first use
+	ollamaSettingsMutex sync.RWMutex
+)
+
+// defaultOllamaConnection returns the connection settings used before any are
+// saved. The OLLAMA_HOST environment variable is honoured.
+func defaultOllamaConnection() ollamaConnection {
+	endpoint := os.Getenv("OLLAMA_HOST")
+	if endpoint == "" {
+		endpoint = defaultOllamaEndpoint
+	}
+	return ollamaConnection{Endpoint: endpoint, Timeout: 5 * time.Minute}
+}
+
+func setOllamaConnection(connection ollamaConnection) {
+	ollamaSettingsMutex.Lock()
+	defer ollamaSettingsMutex.Unlock()
+	ollamaSettings = connection
+
+	// Connections to the old server are closed once their requests finish
+	if ollamaHTTPClient != nil {
+		ollamaHTTPClient.CloseIdleConnections()
+		ollamaHTTPClient = nil
+	}
+}
+
+func getOllamaConnection() ollamaConnection {
+	ollamaSettingsMutex.RLock()
+	defer ollamaSettingsMutex.RUnlock()
+	return ollamaSettings
+}
+
+// newOllamaClient returns an Ollama API client for the configured server. The
+// clients share one HTTP client, so connections are reused between requests.
+func newOllamaClient() (*api.Client, error) {
+	ollamaSettingsMutex.Lock()
+	defer ollamaSettingsMutex.Unlock()
+
+	if ollamaHTTPClient == nil {
+		ollamaHTTPClient = ollamaSettings.httpClient()
+	}
+	return ollamaSettings.client(ollamaHTTPClient)
+}
+
+// client creates an Ollama API client for the connection, sending its
+// requests with the given HTTP client.
+func (c ollamaConnection) client(httpClient *http.Client) (*api.Client, error) {
+	endpoint, err := parseOllamaEndpoint(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return api.NewClient(endpoint, httpClient), nil
+}
+
+// httpClient creates an HTTP client that adds the connection's headers to
+// every request.
+func (c ollamaConnection) httpClient() *http.Client {
+	headers := c.Headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	if c.BearerToken != "" {
+		headers.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: 30 * time.Second}).DialContext
+	transport.ResponseHeaderTimeout = c.Timeout
+
+	// The timeout only covers waiting for a response, as streamed answers can take much longer
+	return &http.Client{Transport: &headerTransport{base: transport, headers: headers}}
+}
+
+// check verifies that an Ollama server answers on the connection.
+func (c ollamaConnection) check(ctx context.Context) error {
+	httpClient := c.httpClient()
+	defer httpClient.CloseIdleConnections()
+
+	client, err := c.client(httpClient)
+	if err != nil {
+		return err
+	}
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Ollama at %s: %w", c.Endpoint, err)
+	}
+
+	fmt.Printf("Connected to Ollama %s at %s\n", version, c.Endpoint)
+	return nil
+}
+
+// parseOllamaEndpoint validates the endpoint URL of an Ollama server.
+func parseOllamaEndpoint(rawUrl string) (*url.URL, error) {
+	parsedUrl, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Ollama endpoint %q: %w", rawUrl, err)
+	}
+	if parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https" {
+		return nil, fmt.Errorf("invalid Ollama endpoint %q: must start with http:// or https://", rawUrl)
+	}
+	if parsedUrl.Host == "" {
+		return nil, fmt.Errorf("invalid Ollama endpoint %q: missing host", rawUrl)
+	}
+	return parsedUrl, nil
+}
+
+// parseHeaders parses custom headers written as one "Name: value" pair per line.
+func parseHeaders(text string) (http.Header, error) {
+	headers := http.Header{}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header on line %d: expected \"Name: value\"", i+1)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// formatHeaders writes headers as one "Name: value" pair per line, the inverse of parseHeaders.
+func formatHeaders(headers http.Header) string {
+	var lines []string
+	for name, values := range headers {
+		for _, value := range values {
+			lines = append(lines, name+": "+value)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// headerTransport adds fixed headers to every request, e.g. for a reverse
+// proxy in front of Ollama.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Requests must not be modified by a RoundTripper, so headers are set on a copy
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		req.Header[name] = values
+	}
+	return t.base.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the underlying transport.
+func (t *headerTransport) CloseIdleConnections() {
+	if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseOllamaEndpoint(t *testing.T) {
+	for _, endpoint := range []string{
+		"http://localhost:11434",
+		"https://ollama.example.com/proxy",
+		"  http://10.0.0.5:11434 ",
+	} {
+		if _, err := parseOllamaEndpoint(endpoint); err != nil {
+			t.Errorf("parseOllamaEndpoint(%q): %v", endpoint, err)
+		}
+	}
+
+	for endpoint, want := range map[string]string{
+		"localhost:11434":       "must start with http:// or https://",
+		"ftp://ollama.example":  "must start with http:// or https://",
+		"ollama.example.com":    "must start with http:// or https://",
+		"http://":               "missing host",
+		"http://local host:80/": "invalid Ollama endpoint",
+	} {
+		if _, err := parseOllamaEndpoint(endpoint); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseOllamaEndpoint(%q) = %v, want an error containing %q", endpoint, err, want)
+		}
+	}
+}
+
+func TestHeadersRoundTrip(t *testing.T) {
+	headers, err := parseHeaders("X-Api-Key: abc:123\n\n  x-team :  Press section \nX-Team: Dryer\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers.Get("X-Api-Key") != "abc:123" || strings.Join(headers.Values("X-Team"), ",") != "Press section,Dryer" {
+		t.Fatalf("headers = %v", headers)
+	}
+
+	parsed, err := parseHeaders(formatHeaders(headers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatHeaders(parsed) != formatHeaders(headers) || len(parsed) != len(headers) {
+		t.Errorf("headers after a round trip = %v, want %v", parsed, headers)
+	}
+
+	for text, want := range map[string]string{
+		"No colon here":        "line 1",
+		"X-Ok: 1\nBad Name: x": "line 2",
+		": no name":            "line 1",
+	} {
+		if _, err := parseHeaders(text); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseHeaders(%q) = %v, want an error for %s", text, err, want)
+		}
+	}
+}
+
+func TestOllamaRequestsCarryTheConnectionHeaders(t *testing.T) {
+	var got http.Header
+	useFakeOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte(`{"version":"0.5.4"}`))
+	})
+	connection := getOllamaConnection()
+	connection.BearerToken = "secret"
+	connection.Headers = http.Header{"X-Api-Key": {"abc"}, "Authorization": {"Basic ignored"}}
+	setOllamaConnection(connection)
+
+	client, err := newOllamaClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Version(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("Authorization") != "Bearer secret" || got.Get("X-Api-Key") != "abc" {
+		t.Errorf("request headers = %v, want the bearer token and the custom header", got)
+	}
+	if connection.Headers.Get("Authorization") != "Basic ignored" {
+		t.Error("the connection's headers were changed")
+	}
+}
+
+func TestOllamaClientReusesConnections(t *testing.T) {
+	var connections atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version":"0.5.4"}`))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	previous := getOllamaConnection()
+	t.Cleanup(func() { setOllamaConnection(previous) })
+	connection := defaultOllamaConnection()
+	connection.Endpoint = server.URL
+	setOllamaConnection(connection)
+
+	for range 3 {
+		client, err := newOllamaClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Version(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := connections.Load(); n != 1 {
+		t.Errorf("%d connections opened for 3 requests, want 1", n)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ollama/ollama/api"
+)
+
+// historyTokenBudget is the approximate number of tokens of earlier turns that
+// are sent with each question. Older turns are dropped first.
+const historyTokenBudget = 1024
+
+// conversation accumulates the user and assistant turns of a chat, so that
+// follow-up questions can refer to earlier answers.
+type conversation struct {
+	mu          sync.Mutex
+	turns       []api.Message
+	tokenBudget int
+}
+
+// newConversation creates an empty conversation that keeps at most
+// tokenBudget tokens of history.
+func newConversation(tokenBudget int) *conversation {
+	return &conversation{tokenBudget: tokenBudget}
+}
+
+// history returns a copy of the turns to send with the next question.
+func (c *conversation) history() []api.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]api.Message(nil), c.turns...)
+}
+
+// addTurn records a question and its answer, dropping the oldest turns if the
+// history no longer fits the token budget.
+func (c *conversation) addTurn(question, answer string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.turns = append(c.turns,
+		api.Message{Role: "user", Content: question},
+		api.Message{Role: "assistant", Content: answer},
+	)
+	c.trim()
+}
+
+// reset forgets all turns of the conversation.
+func (c *conversation) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.turns = nil
+}
+
+// trim drops the oldest question and answer pairs until the history fits the
+// token budget. The latest pair is always kept, shortened if it does not fit
+// on its own. The caller must hold the lock.
+func (c *conversation) trim() {
+	tokens := 0
+	for _, turn := range c.turns {
+		tokens += estimateTokens(turn.Content)
+	}
+
+	for len(c.turns) > 2 && tokens > c.tokenBudget {
+		for _, turn := range c.turns[:2] {
+			tokens -= estimateTokens(turn.Content)
+		}
+		c.turns = c.turns[2:]
+	}
+
+	if tokens > c.tokenBudget && len(c.turns) == 2 {
+		// The question gets up to half of the budget, the answer the rest
+		question := truncateToTokens(c.turns[0].Content, c.tokenBudget/2)
+		answer := truncateToTokens(c.turns[1].Content, c.tokenBudget-estimateTokens(question))
+		c.turns[0].Content, c.turns[1].Content = question, answer
+	}
+}
+
+// truncatedMarker is appended to turns shortened to fit the history.
+const truncatedMarker = " [...]"
+
+// truncateToTokens shortens a text to about the given number of tokens,
+// cutting at a word boundary where possible.
+func truncateToTokens(text string, tokens int) string {
+	if estimateTokens(text) <= tokens {
+		return text
+	}
+
+	limit := max(tokens*4-len(truncatedMarker), 0)
+	cut := text[:limit]
+	for !utf8.ValidString(cut) {
+		cut = cut[:len(cut)-1]
+	}
+	if space := strings.LastIndexAny(cut, " \n\t"); space > limit/2 {
+		cut = cut[:space]
+	}
+	return cut + truncatedMarker
+}
+
+// estimateTokens roughly estimates the number of tokens in a text, assuming
+// about four characters per token as is typical for English text.
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
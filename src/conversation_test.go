@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConversationDropsOldestTurns(t *testing.T) {
+	chat := newConversation(20)
+	chat.addTurn("first question", "first answer")
+	chat.addTurn("second question", "second answer")
+	chat.addTurn("third question", "third answer")
+
+	history := chat.history()
+	if len(history) != 4 {
+		t.Fatalf("history has %d messages, want 4", len(history))
+	}
+	if history[0].Content != "second question" || history[3].Content != "third answer" {
+		t.Errorf("history = %v", history)
+	}
+}
+
+func TestConversationTruncatesOversizedTurn(t *testing.T) {
+	chat := newConversation(50)
+	chat.addTurn("earlier question", "earlier answer")
+	chat.addTurn("What does the manual say?", strings.Repeat("The felt tension is 4.5 kN/m. ", 100))
+
+	// The oversized turn replaces the earlier ones, but is kept shortened
+	history := chat.history()
+	if len(history) != 2 {
+		t.Fatalf("history has %d messages, want 2", len(history))
+	}
+	if history[0].Content != "What does the manual say?" {
+		t.Errorf("question = %q", history[0].Content)
+	}
+	answer := history[1].Content
+	if !strings.HasPrefix(answer, "The felt tension") || !strings.HasSuffix(answer, truncatedMarker) {
+		t.Errorf("answer = %q", answer)
+	}
+
+	tokens := 0
+	for _, turn := range history {
+		tokens += estimateTokens(turn.Content)
+	}
+	if tokens > 50 {
+		t.Errorf("history has %d tokens, budget is 50", tokens)
+	}
+}
+
+func TestTruncateToTokensKeepsValidUTF8(t *testing.T) {
+	text := strings.Repeat("Grüße ", 50)
+	truncated := truncateToTokens(text, 10)
+	if !strings.HasSuffix(truncated, truncatedMarker) || estimateTokens(truncated) > 10 {
+		t.Errorf("truncated = %q", truncated)
+	}
+	if !strings.HasPrefix(truncated, "Grüße") {
+		t.Errorf("truncated = %q", truncated)
+	}
+}
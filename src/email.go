@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// emailHeaders are the headers of a message that are indexed with its text.
+var emailHeaders = []string{"From", "To", "Cc", "Date", "Subject"}
+
+// appendEmailFileContents appends the headers, text and attachments of an
+// email message saved as an .eml file.
+func appendEmailFileContents(w io.Writer, filePath string) error {
+	file, err := openSourceFile(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	message, err := mail.ReadMessage(bufio.NewReader(file))
+	if err != nil {
+		return fmt.Errorf("failed to parse email %s: %w", filePath, err)
+	}
+	return writeEmailMessage(w, message, "")
+}
+
+// appendMboxFileContents appends the messages of an mbox mailbox, each in a
+// section named after its subject and date.
+func appendMboxFileContents(w io.Writer, filePath string) error {
+	file, err := openSourceFile(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var message bytes.Buffer
+	count := 0
+	flush := func() error {
+		if message.Len() == 0 {
+			return nil
+		}
+		defer message.Reset()
+		count++
+
+		parsed, err := mail.ReadMessage(bytes.NewReader(message.Bytes()))
+		if err != nil {
+			// A damaged message should not hide the rest of the mailbox
+			fmt.Printf("Skipping message %d in %s: %v\n", count, filePath, err)
+			return nil
+		}
+		location := emailLocation(parsed.Header, count)
+		startSection(w, location)
+		return writeEmailMessage(w, parsed, location)
+	}
+
+	// Each message starts with a "From " line following a blank line
+	reader := bufio.NewReader(file)
+	previousBlank := true
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if previousBlank && strings.HasPrefix(line, "From ") {
+				if err := flush(); err != nil {
+					return err
+				}
+			} else {
+				// "From " at the start of a line in a message is escaped as ">From "
+				if unescaped := strings.TrimPrefix(line, ">"); strings.HasPrefix(strings.TrimLeft(unescaped, ">"), "From ") {
+					line = unescaped
+				}
+				message.WriteString(line)
+			}
+			previousBlank = strings.TrimRight(line, "\r\n") == ""
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read mailbox %s: %w", filePath, err)
+		}
+	}
+	return flush()
+}
+
+// emailLocation names a message of a mailbox by its subject and date.
+func emailLocation(header mail.Header, number int) string {
+	location := decodeEmailHeader(header.Get("Subject"))
+	if location == "" {
+		location = fmt.Sprintf("message %d", number)
+	}
+	if date, err := header.Date(); err == nil {
+		location += ", " + date.Format("2006-01-02")
+	}
+	return location
+}
+
+// writeEmailMessage writes the headers and the parts of a message. The
+// location is that of the message's section, which attachments are named after.
+func writeEmailMessage(w io.Writer, message *mail.Message, location string) error {
+	var headers strings.Builder
+	for _, name := range emailHeaders {
+		if value := decodeEmailHeader(message.Header.Get(name)); value != "" {
+			headers.WriteString(name + ": " + value + "\n")
+		}
+	}
+	headers.WriteString("\n")
+	if _, err := io.WriteString(w, headers.String()); err != nil {
+		return fmt.Errorf("failed to write email headers: %w", err)
+	}
+
+	return writeEmailPart(w, textproto.MIMEHeader(message.Header), message.Body, location)
+}
+
+// writeEmailPart writes the text of a MIME part, descending into multipart
+// bodies and forwarded messages and extracting attachments.
+func writeEmailPart(w io.Writer, header textproto.MIMEHeader, body io.Reader, location string) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// Parts without a valid content type are plain text
+		mediaType, params = "text/plain", nil
+	}
+	body = decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+
+	if name, ok := emailAttachmentName(header, mediaType, params); ok {
+		return writeEmailAttachment(w, name, body, location)
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		return writeEmailMultipart(w, mediaType, params["boundary"], body, location)
+
+	case mediaType == "message/rfc822":
+		message, err := mail.ReadMessage(bufio.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to parse forwarded email: %w", err)
+		}
+		return writeEmailMessage(w, message, location)
+
+	case mediaType == "text/html":
+		root, err := html.Parse(emailCharsetReader(params["charset"], body))
+		if err != nil {
+			return fmt.Errorf("failed to parse HTML email: %w", err)
+		}
+		out := &htmlText{w: w}
+		out.walk(root)
+		out.endLine()
+		return out.err
+
+	case strings.HasPrefix(mediaType, "text/"):
+		if _, err := io.Copy(w, emailCharsetReader(params["charset"], body)); err != nil {
+			return fmt.Errorf("failed to write email text: %w", err)
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return fmt.Errorf("failed to write newline: %w", err)
+		}
+		return nil
+
+	default:
+		// Inline images, signatures and the like have no text
+		return nil
+	}
+}
+
+// writeEmailMultipart writes the parts of a multipart body. Of alternative
+// parts only one is written, preferring plain text over HTML.
+func writeEmailMultipart(w io.Writer, mediaType, boundary string, body io.Reader, location string) error {
+	if boundary == "" {
+		return fmt.Errorf("multipart email body without boundary")
+	}
+	reader := multipart.NewReader(body, boundary)
+
+	type emailPart struct {
+		header textproto.MIMEHeader
+		body   []byte
+	}
+	var alternatives []emailPart
+
+	for {
+		// Raw parts keep their transfer encoding, which is decoded like that of the message
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read multipart email body: %w", err)
+		}
+
+		if mediaType != "multipart/alternative" {
+			if err := writeEmailPart(w, part.Header, part, location); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := readEmailPart(part)
+		if err != nil {
+			return fmt.Errorf("failed to read multipart email body: %w", err)
+		}
+		alternatives = append(alternatives, emailPart{header: part.Header, body: data})
+	}
+
+	if len(alternatives) == 0 {
+		return nil
+	}
+	chosen := alternatives[len(alternatives)-1]
+	for _, part := range alternatives {
+		if partType, _, err := mime.ParseMediaType(part.header.Get("Content-Type")); err == nil && partType == "text/plain" {
+			chosen = part
+			break
+		}
+	}
+	return writeEmailPart(w, chosen.header, bytes.NewReader(chosen.body), location)
+}
+
+// emailAttachmentName returns the file name of a part that is an attachment
+// rather than part of the message text.
+func emailAttachmentName(header textproto.MIMEHeader, mediaType string, params map[string]string) (string, bool) {
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+
+	name := dispositionParams["filename"]
+	if name == "" {
+		name = params["name"]
+	}
+	if name == "" {
+		return "", false
+	}
+
+	// Text shown inline is part of the message, even if it has a file name
+	if disposition != "attachment" && (strings.HasPrefix(mediaType, "text/") || strings.HasPrefix(mediaType, "multipart/")) {
+		return "", false
+	}
+	return decodeEmailHeader(name), true
+}
+
+// writeEmailAttachment extracts the text of an attachment with the extractor
+// for its file type. Attachments that can not be read are left out, as they
+// should not keep the message itself from being indexed.
+func writeEmailAttachment(w io.Writer, name string, body io.Reader, location string) error {
+	name = filepath.Base(filepath.FromSlash(strings.ReplaceAll(name, `\`, "/")))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return nil
+	}
+	if _, err := io.WriteString(w, "Attachment: "+name+"\n"); err != nil {
+		return fmt.Errorf("failed to write attachment name: %w", err)
+	}
+
+	data, err := readEmailPart(body)
+	if errors.Is(err, errEmailPartTooLarge) {
+		fmt.Printf("Skipping attachment %s: %v\n", name, err)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read attachment %s: %w", name, err)
+	}
+
+	// The extractors read files, so the attachment is saved to a temporary file of the same type
+	dir, err := os.MkdirTemp("", "queryforge-attachment-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary folder: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	attachmentPath := filepath.Join(dir, name)
+	if err := os.WriteFile(attachmentPath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save attachment %s: %w", name, err)
+	}
+
+	attachmentLocation := joinLocation(location, name)
+	startSection(w, attachmentLocation)
+	err = appendFileContents(&attachmentText{w: w, location: attachmentLocation}, attachmentPath)
+	startSection(w, location)
+
+	if err != nil && !errors.Is(err, errUnsupportedFormat) {
+		fmt.Printf("Skipping attachment %s: %v\n", name, err)
+	}
+	return nil
+}
+
+// errEmailPartTooLarge is returned for parts of a message larger than the
+// file size limit, which are not read into memory.
+var errEmailPartTooLarge = errors.New("larger than the file size limit")
+
+// readEmailPart reads a part of a message that is kept in memory, stopping
+// once it is larger than a file may be.
+func readEmailPart(r io.Reader) ([]byte, error) {
+	limit := getIndexLimits().MaxFileSize
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w of %s", errEmailPartTooLarge, formatBytes(limit))
+	}
+	return data, nil
+}
+
+// attachmentText passes the text of an attachment on to the writer of its
+// message, naming the attachment in the location of each section. The pages
+// of attachments are not counted as pages of the message.
+type attachmentText struct {
+	w        io.Writer
+	location string
+}
+
+func (a *attachmentText) Write(p []byte) (int, error) {
+	return a.w.Write(p)
+}
+
+func (a *attachmentText) startSection(location string) {
+	startSection(a.w, joinLocation(a.location, location))
+}
+
+func (a *attachmentText) context() context.Context {
+	return extractionContext(a.w)
+}
+
+func (a *attachmentText) options() folderOptions {
+	return extractionOptions(a.w)
+}
+
+// joinLocation joins the non-empty parts of a location, e.g. "report.pdf, p. 2".
+func joinLocation(parts ...string) string {
+	var nonEmpty []string
+	for _, part := range parts {
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, ", ")
+}
+
+// decodeTransferEncoding decodes a quoted-printable or base64 encoded body.
+func decodeTransferEncoding(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	default:
+		return r
+	}
+}
+
+// emailCharsetReader converts text in the given character set to UTF-8.
+// Unknown character sets are read as UTF-8.
+func emailCharsetReader(label string, r io.Reader) io.Reader {
+	if label == "" {
+		return r
+	}
+	decoded, err := charset.NewReaderLabel(label, r)
+	if err != nil {
+		return r
+	}
+	return decoded
+}
+
+// emailWordDecoder decodes headers containing encoded words such as
+// "=?iso-8859-1?q?Gr=FC=DFe?=".
+var emailWordDecoder = &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+// decodeEmailHeader decodes the encoded words of a header value.
+func decodeEmailHeader(value string) string {
+	decoded, err := emailWordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
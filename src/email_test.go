@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestEmail saves a message with a plain text body and the given file as
+// a base64 encoded attachment, and returns its path.
+func writeTestEmail(t *testing.T, attachment string) string {
+	t.Helper()
+	data, err := os.ReadFile(attachment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var lines []string
+	for len(encoded) > 76 {
+		lines = append(lines, encoded[:76])
+		encoded = encoded[76:]
+	}
+	lines = append(lines, encoded)
+
+	message := "From: Vendor Support <support@vendor.com>\r\n" +
+		"Subject: Press readings\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"The readings of the press section are attached.\r\n" +
+		"--b1\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment; filename=\"" + filepath.Base(attachment) + "\"\r\n" +
+		"\r\n" +
+		strings.Join(lines, "\r\n") + "\r\n" +
+		"--b1--\r\n"
+
+	filePath := filepath.Join(t.TempDir(), "readings.eml")
+	if err := os.WriteFile(filePath, []byte(message), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+// extractedEmailText returns the text of all sections of an extracted email.
+func extractedEmailText(t *testing.T, filePath string, options folderOptions) string {
+	t.Helper()
+	doc, err := extractFile(context.Background(), folderFile{Path: filePath, RelPath: filepath.Base(filePath)}, "", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	for _, section := range doc.Sections {
+		text.WriteString(section.Text)
+	}
+	return text.String()
+}
+
+func TestEmailAttachmentsUseTheFolderOptions(t *testing.T) {
+	filePath := writeTestEmail(t, "testdata/table.pdf")
+
+	if text := extractedEmailText(t, filePath, folderOptions{}); !strings.Contains(text, "ParameterValueUnit") {
+		t.Errorf("plain text = %q, want the table read in plain mode", text)
+	}
+	if text := extractedEmailText(t, filePath, folderOptions{PDFLayout: true}); !strings.Contains(text, "| Pressure | 5.0 | bar |") {
+		t.Errorf("layout text = %q, want the table rows of layout mode", text)
+	}
+}
+
+func TestEmailAttachmentOverFileSizeLimitIsSkipped(t *testing.T) {
+	previousLimits := getIndexLimits()
+	t.Cleanup(func() { setIndexLimits(previousLimits) })
+	limits := previousLimits
+	limits.MaxFileSize = 100
+	setIndexLimits(limits)
+
+	text := extractedEmailText(t, writeTestEmail(t, "testdata/table.pdf"), folderOptions{})
+	if !strings.Contains(text, "The readings of the press section are attached.") {
+		t.Errorf("text = %q, want the message body", text)
+	}
+	if strings.Contains(text, "Parameter") {
+		t.Errorf("text = %q, want the attachment left out", text)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// errBinaryContent is returned for files with a text extension whose contents
+// are not text, e.g. a database dump saved as .log.
+var errBinaryContent = fmt.Errorf("%w: binary content", errUnsupportedFormat)
+
+// binarySampleSize is how much of a file is inspected to detect its encoding.
+const binarySampleSize = 8 * 1024
+
+// openTextFile reads a text file and returns its contents as UTF-8. Files
+// with a byte order mark are decoded accordingly, UTF-16 without a byte order
+// mark is recognised from its zero bytes, and files that are not valid UTF-8
+// are read as Windows-1252, which includes ISO-8859-1.
+func openTextFile(filePath string) (io.Reader, error) {
+	file, err := openSourceFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read text file %s: %w", filePath, err)
+	}
+
+	text, err := decodeText(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", filePath, err)
+	}
+	return bytes.NewReader(text), nil
+}
+
+// decodeText converts text in an unknown encoding to UTF-8.
+func decodeText(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return data[3:], nil
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return decodeWith(unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM), data)
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return decodeWith(unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM), data)
+	}
+
+	sample := data[:min(len(data), binarySampleSize)]
+	if order, ok := detectUTF16(sample); ok {
+		return decodeWith(unicode.UTF16(order, unicode.IgnoreBOM), data)
+	}
+
+	if isBinary(sample) {
+		return nil, errBinaryContent
+	}
+	if utf8.Valid(data) {
+		return data, nil
+	}
+	return decodeWith(charmap.Windows1252, data)
+}
+
+// decodeWith converts text in the given encoding to UTF-8.
+func decodeWith(enc encoding.Encoding, data []byte) ([]byte, error) {
+	text, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return text, nil
+}
+
+// detectUTF16 recognises UTF-16 text without a byte order mark. Latin text in
+// UTF-16 has a zero byte in every other position, either the odd positions
+// for little endian or the even ones for big endian.
+func detectUTF16(sample []byte) (unicode.Endianness, bool) {
+	pairs := len(sample) / 2
+	if pairs < 2 {
+		return unicode.LittleEndian, false
+	}
+
+	evenZeros, oddZeros := 0, 0
+	for i := 0; i+1 < len(sample); i += 2 {
+		if sample[i] == 0 {
+			evenZeros++
+		}
+		if sample[i+1] == 0 {
+			oddZeros++
+		}
+	}
+
+	switch {
+	case oddZeros*10 > pairs*4 && evenZeros*20 < pairs:
+		return unicode.LittleEndian, true
+	case evenZeros*10 > pairs*4 && oddZeros*20 < pairs:
+		return unicode.BigEndian, true
+	default:
+		return unicode.LittleEndian, false
+	}
+}
+
+// isBinary reports whether a sample of a file looks like binary data rather
+// than text: it contains zero bytes, or many control characters.
+func isBinary(sample []byte) bool {
+	controls := 0
+	for _, b := range sample {
+		switch {
+		case b == 0:
+			return true
+		case b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != 0x1B:
+			controls++
+		}
+	}
+	return controls*10 > len(sample)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+// encodeUTF16 returns text in UTF-16 of the given byte order, with or without a byte order mark.
+func encodeUTF16(t *testing.T, text string, order unicode.Endianness, bom unicode.BOMPolicy) string {
+	t.Helper()
+	data, err := unicode.UTF16(order, bom).NewEncoder().String(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecodeText(t *testing.T) {
+	const text = "Grüße from the press section: 5 bar\n"
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "UTF-8", data: text, want: text},
+		{name: "UTF-8 with BOM", data: "\xEF\xBB\xBF" + text, want: text},
+		{name: "UTF-16LE with BOM", data: encodeUTF16(t, text, unicode.LittleEndian, unicode.UseBOM), want: text},
+		{name: "UTF-16BE with BOM", data: encodeUTF16(t, text, unicode.BigEndian, unicode.UseBOM), want: text},
+		{name: "UTF-16LE without BOM", data: encodeUTF16(t, text, unicode.LittleEndian, unicode.IgnoreBOM), want: text},
+		{name: "UTF-16BE without BOM", data: encodeUTF16(t, text, unicode.BigEndian, unicode.IgnoreBOM), want: text},
+		{name: "Windows-1252", data: "Gr\xfc\xdfe \x96 \x80 5\n", want: "Grüße – € 5\n"},
+		{name: "Latin-1", data: "caf\xe9 cr\xe8me\n", want: "café crème\n"},
+		{name: "control characters in text", data: "\x1b[1mBold\x1b[0m\ttab\f\r\n", want: "\x1b[1mBold\x1b[0m\ttab\f\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeText([]byte(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("text = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTextRejectsBinary(t *testing.T) {
+	for name, data := range map[string]string{
+		"PNG":           "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x01\x00",
+		"zero byte":     "Tag PM3-FI-101\x00 range",
+		"control bytes": strings.Repeat("\x01\x02\x03\x04ab", 20),
+		"SQLite header": "SQLite format 3\x00\x10\x00\x01\x01",
+		"random bytes":  "\x07\x8f\x01\xfe\x03\x11\x05\x92\x1f\x02",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if text, err := decodeText([]byte(data)); !errors.Is(err, errBinaryContent) {
+				t.Errorf("text = %q, err = %v, want binary content", text, err)
+			}
+		})
+	}
+}
+
+func TestDetectUTF16(t *testing.T) {
+	if _, ok := detectUTF16([]byte("a\x00")); ok {
+		t.Error("a single character was detected as UTF-16")
+	}
+	if _, ok := detectUTF16([]byte("plain ASCII text")); ok {
+		t.Error("ASCII text was detected as UTF-16")
+	}
+
+	// A few zero bytes in otherwise dense data are not enough
+	if _, ok := detectUTF16([]byte("\x01\x00\x02\x03\x04\x05\x06\x07\x08\x09")); ok {
+		t.Error("data with a single zero byte was detected as UTF-16")
+	}
+
+	sample := []byte(encodeUTF16(t, "Felt tension", unicode.BigEndian, unicode.IgnoreBOM))
+	if order, ok := detectUTF16(sample); !ok || order != unicode.BigEndian {
+		t.Errorf("detectUTF16 = %v, %v, want big endian", order, ok)
+	}
+}
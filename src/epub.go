@@ -0,0 +1,287 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// appendEpubFileContents appends the chapters of an EPUB book in reading
+// order. Each chapter is a section named after its title in the table of
+// contents.
+func appendEpubFileContents(w io.Writer, filePath string) error {
+	archive, file, err := openZipFile(extractionContext(w), filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open EPUB book %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	// The container names the package document, which lists the chapters
+	packagePath, err := readEpubContainer(archive)
+	if err != nil {
+		return fmt.Errorf("failed to read EPUB book %s: %w", filePath, err)
+	}
+	pkg, err := readEpubPackage(archive, packagePath)
+	if err != nil {
+		return fmt.Errorf("failed to read EPUB book %s: %w", filePath, err)
+	}
+
+	titles := readEpubTitles(archive, packagePath, pkg)
+
+	items := map[string]string{}
+	for _, item := range pkg.Manifest {
+		items[item.ID] = epubPath(packagePath, item.Href)
+	}
+
+	for _, ref := range pkg.Spine.Items {
+		chapter, ok := items[ref.IDRef]
+		if !ok {
+			continue
+		}
+
+		var text strings.Builder
+		if err := writeEpubChapter(&text, archive, chapter); err != nil {
+			return fmt.Errorf("failed to extract %s from %s: %w", chapter, filePath, err)
+		}
+
+		// Chapters split over several files only have the first file in the table of contents
+		if title, ok := titles[chapter]; ok {
+			startSection(w, title)
+			if heading := "# " + title + "\n"; !strings.Contains(text.String(), heading) {
+				if _, err := io.WriteString(w, heading); err != nil {
+					return fmt.Errorf("failed to write chapter title: %w", err)
+				}
+			}
+		}
+
+		if _, err := io.WriteString(w, text.String()); err != nil {
+			return fmt.Errorf("failed to write EPUB text: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// epubPackage is the part of an EPUB package document listing the files of
+// the book and their reading order.
+type epubPackage struct {
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine struct {
+		Toc   string `xml:"toc,attr"` // Manifest ID of the EPUB 2 table of contents
+		Items []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+// readEpubContainer returns the archive path of the package document.
+func readEpubContainer(archive *zip.Reader) (string, error) {
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := decodeZipXML(archive, "META-INF/container.xml", &container); err != nil {
+		return "", err
+	}
+	if len(container.Rootfiles) == 0 || container.Rootfiles[0].FullPath == "" {
+		return "", fmt.Errorf("no package document in META-INF/container.xml")
+	}
+	return container.Rootfiles[0].FullPath, nil
+}
+
+// readEpubPackage reads the package document.
+func readEpubPackage(archive *zip.Reader, packagePath string) (epubPackage, error) {
+	var pkg epubPackage
+	err := decodeZipXML(archive, packagePath, &pkg)
+	return pkg, err
+}
+
+// readEpubTitles maps the archive paths of chapters to their titles, taken
+// from the EPUB 3 navigation document or else the EPUB 2 NCX file. Books
+// without a readable table of contents have no chapter titles.
+func readEpubTitles(archive *zip.Reader, packagePath string, pkg epubPackage) map[string]string {
+	titles := map[string]string{}
+	add := func(target, title string) {
+		target, _, _ = strings.Cut(target, "#")
+		title = strings.Join(strings.Fields(title), " ")
+		// Entries for sections within a chapter follow the chapter's own entry
+		if _, ok := titles[target]; !ok && title != "" {
+			titles[target] = title
+		}
+	}
+
+	for _, item := range pkg.Manifest {
+		if !strings.Contains(" "+item.Properties+" ", " nav ") {
+			continue
+		}
+		navPath := epubPath(packagePath, item.Href)
+		entry, err := openZipEntry(archive, navPath)
+		if err != nil {
+			break
+		}
+		root, err := html.Parse(entry)
+		entry.Close()
+		if err != nil {
+			break
+		}
+		for _, link := range epubNavLinks(root) {
+			add(epubPath(navPath, link[0]), link[1])
+		}
+		return titles
+	}
+
+	for _, item := range pkg.Manifest {
+		if item.ID != pkg.Spine.Toc && item.MediaType != "application/x-dtbncx+xml" {
+			continue
+		}
+		type navPoint struct {
+			Label   string `xml:"navLabel>text"`
+			Content struct {
+				Src string `xml:"src,attr"`
+			} `xml:"content"`
+			Points []navPoint `xml:"navPoint"`
+		}
+		var ncx struct {
+			Points []navPoint `xml:"navMap>navPoint"`
+		}
+		ncxPath := epubPath(packagePath, item.Href)
+		if err := decodeZipXML(archive, ncxPath, &ncx); err != nil {
+			break
+		}
+
+		var walk func(points []navPoint)
+		walk = func(points []navPoint) {
+			for _, point := range points {
+				add(epubPath(ncxPath, point.Content.Src), point.Label)
+				walk(point.Points)
+			}
+		}
+		walk(ncx.Points)
+		break
+	}
+
+	return titles
+}
+
+// epubNavLinks returns the targets and texts of the links in the table of
+// contents of a navigation document.
+func epubNavLinks(root *html.Node) [][2]string {
+	var toc *html.Node
+	var findToc func(node *html.Node)
+	findToc = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.DataAtom == atom.Nav {
+			if toc == nil || htmlAttr(node, "epub:type") == "toc" {
+				toc = node
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			findToc(child)
+		}
+	}
+	findToc(root)
+	if toc == nil {
+		return nil
+	}
+
+	var links [][2]string
+	var findLinks func(node *html.Node)
+	findLinks = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.DataAtom == atom.A {
+			if href := htmlAttr(node, "href"); href != "" {
+				links = append(links, [2]string{href, nodeText(node)})
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			findLinks(child)
+		}
+	}
+	findLinks(toc)
+	return links
+}
+
+// writeEpubChapter writes the text of the body of a chapter's XHTML file.
+func writeEpubChapter(w io.Writer, archive *zip.Reader, chapter string) error {
+	entry, err := openZipEntry(archive, chapter)
+	if err != nil {
+		return err
+	}
+	defer entry.Close()
+
+	root, err := html.Parse(entry)
+	if err != nil {
+		return err
+	}
+
+	// The head only repeats the title of the book
+	out := &htmlText{w: w}
+	if body := findHtmlElement(root, atom.Body); body != nil {
+		out.walkChildren(body)
+	} else {
+		out.walk(root)
+	}
+	out.endLine()
+	return out.err
+}
+
+// epubPath resolves a link in the given archive file to an archive path.
+func epubPath(base, href string) string {
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	target, fragment, hasFragment := strings.Cut(href, "#")
+	target = path.Join(path.Dir(base), target)
+	if hasFragment {
+		target += "#" + fragment
+	}
+	return target
+}
+
+// decodeZipXML decodes an XML file of an archive.
+func decodeZipXML(archive *zip.Reader, name string, v any) error {
+	entry, err := openZipEntry(archive, name)
+	if err != nil {
+		return err
+	}
+	defer entry.Close()
+
+	if err := xml.NewDecoder(entry).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// htmlAttr returns the value of an attribute of an HTML element.
+func htmlAttr(node *html.Node, key string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == key || (attr.Namespace != "" && attr.Namespace+":"+attr.Key == key) {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// findHtmlElement returns the first element of the given type, or nil.
+func findHtmlElement(node *html.Node, element atom.Atom) *html.Node {
+	if node.Type == html.ElementNode && node.DataAtom == element {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if found := findHtmlElement(child, element); found != nil {
+			return found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestEpubChaptersFollowTheSpine(t *testing.T) {
+	// Both books list the chapters out of reading order in the manifest and
+	// the table of contents, and continue the first chapter in a second file
+	for _, name := range []string{"handbook3.epub", "handbook2.epub"} {
+		t.Run(name, func(t *testing.T) {
+			file := folderFile{Path: filepath.Join("testdata", name), RelPath: name}
+			doc, err := extractFile(context.Background(), file, "", folderOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var locations, texts []string
+			for _, section := range doc.Sections {
+				locations = append(locations, section.Location)
+				texts = append(texts, section.Text)
+			}
+			if want := []string{"Felt care", "Felt tension", "Guide rolls"}; !slices.Equal(locations, want) {
+				t.Fatalf("sections = %q, want %q", locations, want)
+			}
+
+			want := []string{
+				"# Felt care\nWash the felt every shift.\nDry the felt before storing it.\n",
+				"# Felt tension\nKeep the felt tension at 4.5 kN/m.\n## Limits\nNever exceed 6 kN/m.\n",
+				"# Guide rolls\nReplace worn guide rolls.\n",
+			}
+			for i := range want {
+				if texts[i] != want[i] {
+					t.Errorf("section %q = %q, want %q", locations[i], texts[i], want[i])
+				}
+			}
+		})
+	}
+}
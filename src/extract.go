@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+// extractJob is a file that is new or changed since the folder was last indexed.
+type extractJob struct {
+	file    folderFile
+	oldHash string // Hash of the indexed version of the file, "" for new files
+}
+
+// extraction is the outcome of reading a file for the index.
+type extraction struct {
+	doc      document
+	sameHash bool // The contents did not change, so the file was not extracted
+	err      error
+	duration time.Duration
+}
+
+// extractFiles hashes and extracts files on a bounded pool of workers, as
+// large PDFs take long to parse. The results are in the same order as the
+// jobs, so the index does not depend on which worker finished first. Each tar
+// archive is read only once, handing its entries to the workers as they are
+// read.
+func extractFiles(ctx context.Context, jobs []extractJob, options folderOptions, limits indexLimits) []extraction {
+	results := make([]extraction, len(jobs))
+
+	workers := limits.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(jobs))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = extractJobFile(ctx, jobs[i], options, limits.FileTimeout)
+				forgetTarEntry(jobs[i].file.Path)
+			}
+		}()
+	}
+
+	// Entries of tar archives are handed out while reading each archive once
+	var tarArchives []string
+	tarJobs := make(map[string]map[string]int) // Job index by entry, for each archive
+	for i, job := range jobs {
+		archivePath, entry, ok := splitArchivePath(job.file.Path)
+		if !ok || isZipArchive(archivePath) {
+			next <- i
+			continue
+		}
+		if tarJobs[archivePath] == nil {
+			tarArchives = append(tarArchives, archivePath)
+			tarJobs[archivePath] = make(map[string]int)
+		}
+		tarJobs[archivePath][entry] = i
+	}
+	for _, archivePath := range tarArchives {
+		entries := slices.Sorted(maps.Keys(tarJobs[archivePath]))
+		readTarArchive(archivePath, entries, func(entry string) {
+			next <- tarJobs[archivePath][entry]
+		})
+	}
+
+	close(next)
+	wg.Wait()
+
+	return results
+}
+
+// extractJobFile hashes a file and extracts its text if the contents changed.
+// The hash is returned with failed extractions as well, so a failed file is
+// not read again until its contents change.
+func extractJobFile(ctx context.Context, job extractJob, options folderOptions, timeout time.Duration) extraction {
+	started := time.Now()
+	result := func(doc document, sameHash bool, err error) extraction {
+		return extraction{doc: doc, sameHash: sameHash, err: err, duration: time.Since(started)}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return result(document{}, false, err)
+	}
+
+	hash, err := hashFile(job.file.Path)
+	if err != nil {
+		return result(document{}, false, err)
+	}
+	if job.oldHash == hash {
+		return result(document{folderFile: job.file, Hash: hash}, true, nil)
+	}
+
+	doc, err := extractWithTimeout(ctx, job.file, hash, options, timeout)
+	if err != nil {
+		return result(document{folderFile: job.file, Hash: hash}, false, err)
+	}
+	return result(doc, false, nil)
+}
+
+// errExtractionTimedOut is returned for files whose extraction ran out of the
+// file timeout. They are read again once the timeout is raised.
+var errExtractionTimedOut = errors.New("extraction timed out")
+
+// extractWithTimeout extracts a file, stopping the extraction once the timeout
+// passes so a single pathological file can not stall the whole folder. The
+// extractors stop at their next write or check of the extraction context, and
+// the worker waits for that, so no extraction keeps running in the background.
+func extractWithTimeout(ctx context.Context, file folderFile, hash string, options folderOptions, timeout time.Duration) (document, error) {
+	extractCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		extractCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	doc, err := recoverExtractFile(extractCtx, file, hash, options)
+	if err := ctx.Err(); err != nil {
+		return document{}, err
+	}
+	if extractCtx.Err() != nil {
+		fmt.Printf("Extraction of %s timed out after %s\n", file.Path, timeout)
+		return document{}, fmt.Errorf("%w after %s", errExtractionTimedOut, timeout)
+	}
+	return doc, err
+}
+
+// recoverExtractFile extracts a file, turning a panic of the extractor into an
+// error. The PDF library panics on some malformed files, which would otherwise
+// take down the whole application.
+func recoverExtractFile(ctx context.Context, file folderFile, hash string, options folderOptions) (doc document, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Extractor panicked on %s: %v\n%s", file.Path, r, debug.Stack())
+			doc, err = document{}, fmt.Errorf("extractor crashed: %v", r)
+		}
+	}()
+
+	return extractFile(ctx, file, hash, options)
+}
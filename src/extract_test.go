@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// copyTestdataFiles copies each testdata file n times into a new folder and
+// returns the extraction jobs for the copies.
+func copyTestdataFiles(tb testing.TB, n int, names ...string) []extractJob {
+	tb.Helper()
+	folder := tb.TempDir()
+
+	var jobs []extractJob
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		for i := range n {
+			relPath := fmt.Sprintf("%d-%s", i, name)
+			filePath := filepath.Join(folder, relPath)
+			if err := os.WriteFile(filePath, data, 0o644); err != nil {
+				tb.Fatal(err)
+			}
+			jobs = append(jobs, extractJob{file: folderFile{Path: filePath, RelPath: relPath, Size: int64(len(data))}})
+		}
+	}
+	return jobs
+}
+
+func TestExtractFilesKeepsJobOrder(t *testing.T) {
+	jobs := copyTestdataFiles(t, 3, "report.docx", "deck.pptx", "notes.odt")
+	limits := defaultIndexLimits()
+	limits.Workers = 4
+
+	for i, result := range extractFiles(context.Background(), jobs, folderOptions{}, limits) {
+		if result.err != nil {
+			t.Fatalf("%s: %v", jobs[i].file.RelPath, result.err)
+		}
+		if result.doc.RelPath != jobs[i].file.RelPath || result.doc.Hash == "" {
+			t.Errorf("result %d is for %s, want %s", i, result.doc.RelPath, jobs[i].file.RelPath)
+		}
+	}
+}
+
+func TestExtractWithTimeoutStopsTheExtraction(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "large.txt")
+	if err := os.WriteFile(filePath, []byte(strings.Repeat("Felt tension is 4.5 kN/m.\n", 1<<18)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file := folderFile{Path: filePath, RelPath: "large.txt"}
+
+	// The deadline has passed before the first write, so no text may be collected
+	started := time.Now()
+	doc, err := extractWithTimeout(context.Background(), file, "", folderOptions{}, time.Nanosecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+	if len(doc.Sections) != 0 {
+		t.Errorf("timed out extraction returned %d sections", len(doc.Sections))
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("extraction took %s after its deadline", elapsed)
+	}
+
+	// The job keeps the hash, so the failure is recorded for these contents
+	result := extractJobFile(context.Background(), extractJob{file: file}, folderOptions{}, time.Nanosecond)
+	if result.err == nil || result.doc.Hash == "" {
+		t.Errorf("result = %+v, want the error and the hash", result)
+	}
+}
+
+func TestTextSectionsRejectWritesOnceCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	text := textSections{ctx: ctx}
+	if _, err := text.Write([]byte("before")); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	if _, err := text.Write([]byte("after")); err != context.Canceled {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if extractionContext(&text).Err() == nil {
+		t.Error("extraction context is not canceled")
+	}
+	if sections := text.all(); len(sections) != 1 || sections[0].Text != "before" {
+		t.Errorf("sections = %v", sections)
+	}
+}
+
+func TestPdfPagesAreCited(t *testing.T) {
+	useFakeEmbeddings(t)
+	file := folderFile{Path: "testdata/manual.pdf", RelPath: "manual.pdf"}
+	doc, err := extractFile(context.Background(), file, "", folderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each page is a section of its own, and the blank second page has none
+	want := []textSection{
+		{Location: "p. 1", Text: "Pump manual page oneSeal pressure 5 bar\n"},
+		{Location: "p. 3", Text: "Page three text\n"},
+	}
+	if doc.Pages != 3 || !slices.Equal(doc.Sections, want) {
+		t.Fatalf("pages = %d, sections = %q, want 3 pages and %q", doc.Pages, doc.Sections, want)
+	}
+
+	// The chunks keep the page, so the prompt cites it
+	indexed, err := embedDocument(context.Background(), doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompt, err := renderUserPrompt("What is the seal pressure?", indexed.Chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, citation := range []string{"[manual.pdf, p. 1]\nPump manual page one", "[manual.pdf, p. 3]\nPage three text"} {
+		if !strings.Contains(prompt, citation) {
+			t.Errorf("prompt =\n%s\nwant it to contain %q", prompt, citation)
+		}
+	}
+}
+
+func BenchmarkExtractFiles(b *testing.B) {
+	jobs := copyTestdataFiles(b, 16, "manual.pdf", "report.docx", "deck.pptx", "notes.odt", "deck.odp")
+
+	for _, workers := range []int{1, max(runtime.GOMAXPROCS(0), 4)} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			limits := defaultIndexLimits()
+			limits.Workers = workers
+			for range b.N {
+				extractFiles(context.Background(), jobs, folderOptions{}, limits)
+			}
+		})
+	}
+}
+
+func TestCanceledExtractionsStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, name := range []string{"manual.pdf", "report.docx", "deck.pptx", "notes.odt", "readings.xlsx", "tags.csv"} {
+		for _, options := range []folderOptions{{}, {PDFLayout: true}} {
+			file := folderFile{Path: filepath.Join("testdata", name), RelPath: name}
+			doc, err := extractFile(ctx, file, "", options)
+			if err == nil || len(doc.Sections) != 0 {
+				t.Errorf("%s: err = %v with %d sections, want an error and no text", name, err, len(doc.Sections))
+			}
+		}
+	}
+}
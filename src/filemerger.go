@@ -1,113 +1,508 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/ledongthuc/pdf"
 )
 
-var tempFileLocation string // Global variable to store the temporary file location
+// folderFile is a file found while walking the selected folder.
+type folderFile struct {
+	Path    string // Absolute path of the file
+	RelPath string // Path relative to the selected folder, with forward slashes
+	ModTime time.Time
+	Size    int64
+}
 
-// mergeFilesToTemp reads all files from the selected directory and outputs their
-// combined content into a single temporary file, and returns its location.
-func mergeFilesToTemp(dir string) (string, error) {
-	tempDir := os.TempDir()
-	tempFile, err := os.CreateTemp(tempDir, "merged_*.txt")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temporary file: %w", err)
+// document is the text extracted from a single file in the selected folder.
+type document struct {
+	folderFile
+	Hash     string // SHA-256 of the file contents
+	Pages    int    // Pages or slides, 0 for formats without pages
+	Sections []textSection
+}
+
+// characters returns the number of characters of text extracted from the document.
+func (d document) characters() int {
+	count := 0
+	for _, section := range d.Sections {
+		count += utf8.RuneCountInString(section.Text)
+	}
+	return count
+}
+
+// textSection is a part of a document that is chunked on its own, such as a
+// group of spreadsheet rows.
+type textSection struct {
+	Location string // Where the section is found in the file, e.g. "Sheet1, rows 2-26"
+	Text     string
+}
+
+// sectionWriter is implemented by writers that split extracted text into
+// sections. Extractors call startSection through the startSection function.
+type sectionWriter interface {
+	startSection(location string)
+}
+
+// startSection starts a new section of extracted text, if the writer keeps
+// sections. Text written afterwards belongs to the new section.
+func startSection(w io.Writer, location string) {
+	if sections, ok := w.(sectionWriter); ok {
+		sections.startSection(location)
+	}
+}
+
+// pageCounter is implemented by writers that keep track of the number of
+// pages extracted. Extractors call addPages through the addPages function.
+type pageCounter interface {
+	addPages(n int)
+}
+
+// addPages records that n pages or slides were extracted, if the writer counts them.
+func addPages(w io.Writer, n int) {
+	if counter, ok := w.(pageCounter); ok {
+		counter.addPages(n)
+	}
+}
+
+// contextWriter is implemented by writers whose extraction can be stopped,
+// e.g. when the file timed out. Extractors call context through the
+// extractionContext function.
+type contextWriter interface {
+	context() context.Context
+}
+
+// extractionContext returns the context of the extraction writing to w.
+// Extractors that work for a long time between writes check it, so that they
+// stop once the extraction is canceled.
+func extractionContext(w io.Writer) context.Context {
+	if writer, ok := w.(contextWriter); ok {
+		return writer.context()
+	}
+	return context.Background()
+}
+
+// optionsWriter is implemented by writers that know the options of the folder
+// being indexed. Extractors call options through the extractionOptions function.
+type optionsWriter interface {
+	options() folderOptions
+}
+
+// extractionOptions returns the options of the folder whose file is written
+// to w, so that files found inside other files are read the same way.
+func extractionOptions(w io.Writer) folderOptions {
+	if writer, ok := w.(optionsWriter); ok {
+		return writer.options()
+	}
+	return folderOptions{}
+}
+
+// textSections collects the text written by the extractors, split into sections.
+type textSections struct {
+	ctx      context.Context // Writes fail once it is canceled, nil if the extraction can not be stopped
+	opts     folderOptions
+	sections []textSection
+	location string
+	current  strings.Builder
+	pages    int
+}
+
+func (t *textSections) Write(p []byte) (int, error) {
+	if err := t.context().Err(); err != nil {
+		return 0, err
+	}
+	return t.current.Write(p)
+}
+
+func (t *textSections) context() context.Context {
+	if t.ctx == nil {
+		return context.Background()
 	}
+	return t.ctx
+}
 
-	fmt.Printf("Temporary file created at: %s\n", tempFile.Name())
+func (t *textSections) options() folderOptions {
+	return t.opts
+}
+
+func (t *textSections) addPages(n int) {
+	t.pages += n
+}
 
-	fileCount := 0
-	// Traverse the directory and process each file
+func (t *textSections) startSection(location string) {
+	t.flush()
+	t.location = location
+}
+
+// flush finishes the current section, dropping it if it holds no text.
+func (t *textSections) flush() {
+	if strings.TrimSpace(t.current.String()) != "" {
+		t.sections = append(t.sections, textSection{Location: t.location, Text: t.current.String()})
+	}
+	t.current.Reset()
+}
+
+// all returns all sections written so far.
+func (t *textSections) all() []textSection {
+	t.flush()
+	return t.sections
+}
+
+// folderListing is the result of walking the selected folder.
+type folderListing struct {
+	Files   []folderFile // Files to index
+	Skipped []fileReport // Files that are unsupported, unreadable or beyond the limits
+	Ignored int          // Files and folders left out by the ignore and include patterns
+}
+
+// listFolderFiles walks the selected directory and returns the files that
+// should be indexed, without reading them. Ignored files are left out, and
+// files that are unsupported, unreadable or beyond the limits are reported.
+func listFolderFiles(dir string, options folderOptions, limits indexLimits) (folderListing, error) {
+	var listing folderListing
+
+	filter, err := newPathFilter(dir, options)
+	if err != nil {
+		return listing, err
+	}
+
+	// Traverse the directory and collect each file
 	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		relPath, relErr := filepath.Rel(dir, path)
+		if relErr != nil {
+			return fmt.Errorf("failed to resolve path of %s: %w", path, relErr)
+		}
+		relPath = filepath.ToSlash(relPath)
+
 		if err != nil {
-			return err
+			if path == dir {
+				return err
+			}
+			// An unreadable subfolder or file should not stop the rest of the folder
+			listing.Skipped = append(listing.Skipped, fileReport{Path: relPath, Status: fileFailed, Reason: err.Error()})
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			if path == dir {
+				return nil
+			}
+			// Ignored folders such as .git or node_modules are not walked at all
+			if filter.ignored(relPath, true) {
+				listing.Ignored++
+				return filepath.SkipDir
+			}
+			depth := strings.Count(relPath, "/") + 1
+			if err := limits.checkDepth(depth); err != nil {
+				listing.Skipped = append(listing.Skipped, fileReport{Path: relPath + "/", Status: fileOverLimit, Reason: err.Error()})
+				return filepath.SkipDir
+			}
+			return nil
 		}
 
-		// Skip directories and specific unwanted files like .DS_Store
-		if info.IsDir() || strings.EqualFold(info.Name(), ".ds_store") {
+		// Skip hidden, temporary and other unwanted files like .DS_Store
+		if filter.ignored(relPath, false) {
+			listing.Ignored++
 			return nil
 		}
 
-		fileCount++
-		if fileCount > 50 {
-			return fmt.Errorf("too many files in directory: %d", fileCount)
+		// The files inside archives are indexed as if the archive was a subfolder
+		if isArchive(path) {
+			listing.addArchive(path, relPath, filter, options, limits)
+			return nil
 		}
 
-		// Append valid files to the temp file
-		if err := appendFileContents(tempFile, path); err != nil {
-			return fmt.Errorf("failed to process file %s: %w", path, err)
+		if !filter.included(relPath) {
+			listing.Ignored++
+			return nil
 		}
+
+		listing.add(folderFile{
+			Path:    path,
+			RelPath: relPath,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+		}, options, limits)
 		return nil
 	})
 	if err != nil {
-		_ = tempFile.Close()
-		_ = os.Remove(tempFile.Name()) // Clean up temp file on error
+		return folderListing{}, err
+	}
+
+	return listing, nil
+}
+
+// add adds a file to the listing, unless it is unsupported or beyond the limits.
+func (l *folderListing) add(file folderFile, options folderOptions, limits indexLimits) {
+	// Unsupported files are reported, but do not count towards the file limit
+	if findExtractor(file.Path, options) == nil {
+		l.Skipped = append(l.Skipped, fileReport{
+			Path:   file.RelPath,
+			Status: fileUnsupported,
+			Reason: unsupportedFormatError(file.Path).Error(),
+			Bytes:  file.Size,
+		})
+		return
+	}
+
+	if err := limits.checkFile(len(l.Files), file.Size); err != nil {
+		l.Skipped = append(l.Skipped, fileReport{Path: file.RelPath, Status: fileOverLimit, Reason: err.Error(), Bytes: file.Size})
+		return
+	}
+
+	l.Files = append(l.Files, file)
+}
+
+// addArchive adds the files inside an archive to the listing. The ignore and
+// include patterns are matched against the paths of the entries relative to
+// the selected folder, e.g. "docs/pkg.zip!/manual.pdf", so "docs/**/*.pdf"
+// matches inside archives as well and "docs/pkg.zip!/drafts/" leaves out a
+// folder of a single archive.
+func (l *folderListing) addArchive(archivePath, relPath string, filter *pathFilter, options folderOptions, limits indexLimits) {
+	entries, err := listArchiveEntries(archivePath)
+	if err != nil {
+		l.Skipped = append(l.Skipped, fileReport{Path: relPath, Status: fileFailed, Reason: err.Error()})
+	}
+
+	for _, entry := range entries {
+		entryPath := archiveEntryPath(relPath, entry.Name)
+		if filter.ignored(entryPath, false) || !filter.included(entryPath) {
+			l.Ignored++
+			continue
+		}
+		if entry.err != nil {
+			l.Skipped = append(l.Skipped, fileReport{Path: entryPath, Status: fileOverLimit, Reason: entry.err.Error(), Bytes: entry.Size})
+			continue
+		}
+
+		l.add(folderFile{
+			Path:    archiveEntryPath(archivePath, entry.Name),
+			RelPath: entryPath,
+			ModTime: entry.ModTime,
+			Size:    entry.Size,
+		}, options, limits)
+	}
+}
+
+// extractFile reads the text of a single file found in the selected folder.
+// The extraction stops once the context is canceled.
+func extractFile(ctx context.Context, file folderFile, hash string, options folderOptions) (document, error) {
+	extract := findExtractor(file.Path, options)
+	if extract == nil {
+		return document{}, unsupportedFormatError(file.Path)
+	}
+
+	text := textSections{ctx: ctx, opts: options}
+	if err := extract(&text, file.Path); err != nil {
+		return document{}, err
+	}
+
+	return document{folderFile: file, Hash: hash, Pages: text.pages, Sections: text.all()}, nil
+}
+
+// hashFile returns the hex encoded SHA-256 hash of a file's contents.
+func hashFile(filePath string) (string, error) {
+	file, err := openSourceFile(filePath)
+	if err != nil {
 		return "", err
 	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash file %s: %w", filePath, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// defaultPlainTextExtensions are the extensions of text-like files that are
+// indexed as plain text, unless changed in the settings.
+var defaultPlainTextExtensions = []string{
+	".txt", ".log", ".json", ".xml", ".yaml", ".yml", ".ini", ".cfg", ".conf", ".toml", ".properties",
+	".go", ".py", ".js", ".ts", ".java", ".c", ".h", ".cpp", ".cs", ".sql", ".sh", ".bat", ".ps1",
+}
+
+var (
+	plainTextExtensions      = extensionSet(defaultPlainTextExtensions)
+	plainTextExtensionsMutex sync.RWMutex
+)
+
+// setPlainTextExtensions sets the extensions of files indexed as plain text.
+func setPlainTextExtensions(extensions []string) {
+	plainTextExtensionsMutex.Lock()
+	defer plainTextExtensionsMutex.Unlock()
+	plainTextExtensions = extensionSet(extensions)
+}
+
+// isPlainTextExtension reports whether files with the extension are indexed as plain text.
+func isPlainTextExtension(ext string) bool {
+	plainTextExtensionsMutex.RLock()
+	defer plainTextExtensionsMutex.RUnlock()
+	return plainTextExtensions[strings.ToLower(ext)]
+}
 
-	if err := tempFile.Close(); err != nil {
-		return "", fmt.Errorf("failed to close temporary file: %w", err)
+// extensionSet returns a set of the given extensions.
+func extensionSet(extensions []string) map[string]bool {
+	set := make(map[string]bool, len(extensions))
+	for _, ext := range extensions {
+		set[ext] = true
 	}
+	return set
+}
 
-	return tempFile.Name(), nil
+// parseExtensions splits a comma or space separated list of file extensions,
+// returning them in lower case and each starting with a dot.
+func parseExtensions(text string) []string {
+	var extensions []string
+	for _, ext := range strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ' ' || r == ';' || r == '\n'
+	}) {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		extensions = append(extensions, ext)
+	}
+	return extensions
 }
 
-// appendFileContents appends the contents of a file to the temporary file.
-func appendFileContents(tempFile *os.File, filePath string) error {
+// errUnsupportedFormat is returned for files that none of the extractors can read.
+var errUnsupportedFormat = errors.New("unsupported file format")
+
+// extractor appends the text of a file to a writer.
+type extractor func(w io.Writer, filePath string) error
+
+// findExtractor returns the extractor for a file, or nil if its format is not
+// supported. The folder options choose between alternative extractors.
+func findExtractor(filePath string, options folderOptions) extractor {
 	ext := strings.ToLower(filepath.Ext(filePath))
 
 	switch ext {
 	case ".txt":
-		return appendTextFileContents(tempFile, filePath)
+		return appendTextFileContents
 	case ".pdf":
-		return appendPdfFileContents(tempFile, filePath)
+		if options.PDFLayout {
+			return appendPdfLayoutFileContents
+		}
+		return appendPdfFileContents
+	case ".docx":
+		return appendDocxFileContents
+	case ".pptx":
+		return appendPptxFileContents
+	case ".odt", ".odp":
+		return appendOpenDocumentFileContents
+	case ".xlsx":
+		return appendXlsxFileContents
+	case ".csv":
+		return func(w io.Writer, filePath string) error {
+			return appendDelimitedFileContents(w, filePath, ',')
+		}
+	case ".tsv":
+		return func(w io.Writer, filePath string) error {
+			return appendDelimitedFileContents(w, filePath, '\t')
+		}
+	case ".html", ".htm", ".xhtml":
+		return appendHtmlFileContents
+	case ".md", ".markdown":
+		return appendMarkdownFileContents
+	case ".epub":
+		return appendEpubFileContents
+	case ".rtf":
+		return appendRtfFileContents
+	case ".eml":
+		return appendEmailFileContents
+	case ".mbox":
+		return appendMboxFileContents
 	default:
-		return fmt.Errorf("unsupported file format: %s", ext)
+		if isPlainTextExtension(ext) {
+			return appendTextFileContents
+		}
+		// Images are only indexed once a vision model is chosen to describe them
+		if isImageFile(filePath) && getVisionModelName() != "" {
+			return appendImageFileContents
+		}
+		return nil
+	}
+}
+
+// unsupportedFormatError returns the error for a file that no extractor can read.
+func unsupportedFormatError(filePath string) error {
+	return fmt.Errorf("%w: %s", errUnsupportedFormat, strings.ToLower(filepath.Ext(filePath)))
+}
+
+// appendFileContents appends the contents of a file to the writer, using the
+// extractor for its format and the options of the folder being indexed.
+func appendFileContents(w io.Writer, filePath string) error {
+	extract := findExtractor(filePath, extractionOptions(w))
+	if extract == nil {
+		return unsupportedFormatError(filePath)
 	}
+	return extract(w, filePath)
 }
 
-// appendTextFileContents appends the contents of a text file.
-func appendTextFileContents(tempFile *os.File, filePath string) error {
-	file, err := os.Open(filePath)
+// appendTextFileContents appends the contents of a text file, converted to UTF-8.
+func appendTextFileContents(w io.Writer, filePath string) error {
+	file, err := openTextFile(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to open text file %s: %w", filePath, err)
+		return err
 	}
-	defer file.Close()
 
-	_, err = io.Copy(tempFile, file)
+	_, err = io.Copy(w, file)
 	if err != nil {
 		return fmt.Errorf("failed to copy text file contents: %w", err)
 	}
 
-	if _, err := tempFile.WriteString("\n"); err != nil {
+	if _, err := io.WriteString(w, "\n"); err != nil {
 		return fmt.Errorf("failed to write newline: %w", err)
 	}
 
 	return nil
 }
 
-// appendPdfFileContents appends the contents of a PDF file.
-func appendPdfFileContents(tempFile *os.File, filePath string) error {
+// appendPdfFileContents appends the contents of a PDF file, one section per
+// page, so that answers can cite the page a passage was found on.
+func appendPdfFileContents(w io.Writer, filePath string) error {
 	// Open the PDF file
-	f, r, err := pdf.Open(filePath)
+	f, err := openSourceFile(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to open PDF file %s: %w", filePath, err)
+		return err
 	}
 	defer f.Close()
 
+	// The PDF library can not be interrupted, but fails once its reads do
+	ctx := extractionContext(w)
+	r, err := pdf.NewReader(cancelableReaderAt{ctx: ctx, r: f}, f.Size())
+	if err != nil {
+		return fmt.Errorf("failed to open PDF file %s: %w", filePath, err)
+	}
+
 	// Loop through all pages to extract text
 	totalPages := r.NumPage()
+	addPages(w, totalPages)
 	for i := 1; i <= totalPages; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		startSection(w, pageLocation(i))
+
+		// Blank pages and pages missing from the page tree have no text
 		page := r.Page(i)
 		if page.V.IsNull() {
-			return fmt.Errorf("failed to read page %d in PDF %s", i, filePath)
+			continue
 		}
 
 		// Extract text from the page
@@ -116,8 +511,8 @@ func appendPdfFileContents(tempFile *os.File, filePath string) error {
 			return fmt.Errorf("failed to extract text from page %d in PDF %s: %w", i, filePath, err)
 		}
 
-		// Write the extracted text to the temporary file
-		if _, err := tempFile.WriteString(text + "\n"); err != nil {
+		// Write the extracted text
+		if _, err := io.WriteString(w, text+"\n"); err != nil {
 			return fmt.Errorf("failed to write PDF text: %w", err)
 		}
 	}
@@ -125,32 +520,28 @@ func appendPdfFileContents(tempFile *os.File, filePath string) error {
 	return nil
 }
 
-// deleteTempFile deletes the specified temporary file.
-func deleteTempFile() error {
-	if err := os.Remove(tempFileLocation); err != nil {
-		return fmt.Errorf("can not delete temporary file: %w", err)
-	}
-	return nil
-}
-
-// getTempFileLocation returns the location of the temporary file.
-func getTempFileLocation() string {
-	return tempFileLocation
+// pageLocation returns the location of a page for citations, e.g. "p. 42".
+func pageLocation(page int) string {
+	return fmt.Sprintf("p. %d", page)
 }
 
-// setTempFileLocation sets the location of the temporary file.
-func setTempFileLocation(location string) {
-	tempFileLocation = location
-}
-
-// NOTE: Uncomment the main function to run the file merger
+// NOTE: Uncomment the main function to run the file extraction
 // func main() {
 // 	// Change "your_directory_path" to the directory you want to process
 // 	directory := "your_directory_path"
 
-// 	if err := mergeFilesToTemp(directory); err != nil {
+// 	listing, err := listFolderFiles(directory, folderOptions{}, defaultIndexLimits())
+// 	if err != nil {
 // 		fmt.Printf("Error: %v\n", err)
-// 	} else {
-// 		fmt.Println("Temporary file processed and deleted successfully.")
+// 		return
+// 	}
+
+// 	for _, file := range listing.Files {
+// 		doc, err := extractFile(context.Background(), file, "", folderOptions{})
+// 		if err != nil {
+// 			fmt.Printf("Error: %v\n", err)
+// 			continue
+// 		}
+// 		fmt.Printf("Extracted %d sections from %s.\n", len(doc.Sections), doc.RelPath)
 // 	}
 // }
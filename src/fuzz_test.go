@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// fuzzTimeout is the deadline of each fuzzed extraction, and fuzzSlack the time
+// an extractor may take to notice that it passed.
+const (
+	fuzzTimeout = 2 * time.Second
+	fuzzSlack   = time.Second
+)
+
+// fuzzExtract fuzzes the extractor of files with the given extension, seeded
+// with the testdata files. Extractors may fail on malformed input, but must
+// not panic and must stop once the deadline passes.
+func fuzzExtract(f *testing.F, ext string, options folderOptions, seeds ...string) {
+	for _, seed := range seeds {
+		data, err := os.ReadFile(filepath.Join("testdata", seed))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		filePath := filepath.Join(t.TempDir(), "fuzz"+ext)
+		if err := os.WriteFile(filePath, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		file := folderFile{Path: filePath, RelPath: "fuzz" + ext, Size: int64(len(data))}
+
+		// Panics are turned into errors, so any panic that escapes fails the test
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = extractWithTimeout(context.Background(), file, "", options, fuzzTimeout)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(fuzzTimeout + fuzzSlack):
+			stacks := make([]byte, 1<<20)
+			stacks = stacks[:runtime.Stack(stacks, true)]
+			t.Fatalf("extraction did not stop after its %s deadline\n%s", fuzzTimeout, stacks)
+		}
+	})
+}
+
+func FuzzExtractPdf(f *testing.F) {
+	fuzzExtract(f, ".pdf", folderOptions{}, "manual.pdf")
+}
+
+func FuzzExtractPdfLayout(f *testing.F) {
+	fuzzExtract(f, ".pdf", folderOptions{PDFLayout: true}, "manual.pdf")
+}
+
+func FuzzExtractDocx(f *testing.F) {
+	fuzzExtract(f, ".docx", folderOptions{}, "report.docx")
+}
+
+func FuzzExtractPptx(f *testing.F) {
+	fuzzExtract(f, ".pptx", folderOptions{}, "deck.pptx")
+}
+
+func FuzzExtractOpenDocument(f *testing.F) {
+	fuzzExtract(f, ".odt", folderOptions{}, "notes.odt", "deck.odp", "spaces.odt")
+}
+
+func FuzzExtractXlsx(f *testing.F) {
+	fuzzExtract(f, ".xlsx", folderOptions{}, "readings.xlsx")
+}
+
+func FuzzExtractCsv(f *testing.F) {
+	fuzzExtract(f, ".csv", folderOptions{}, "tags.csv")
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// ignoreFileName is the name of the file listing the files in a folder that
+// should not be indexed, using the same syntax as .gitignore.
+const ignoreFileName = ".queryforgeignore"
+
+// defaultIgnorePatterns leave out hidden files, version control folders and
+// the temporary, backup and lock files editors and Office leave behind. They
+// can be overridden with "!" patterns in the ignore file.
+var defaultIgnorePatterns = []string{
+	".*",
+	"~$*",
+	"*~",
+	"*.tmp",
+	"*.temp",
+	"*.bak",
+	"*.swp",
+	"Thumbs.db",
+	"desktop.ini",
+	"node_modules/",
+	"__pycache__/",
+	"__MACOSX/",
+}
+
+// ignoreCase makes the patterns match regardless of case, as file names do on
+// the default file systems of Windows and macOS. "Thumbs.db" then also leaves
+// out "thumbs.db".
+var ignoreCase = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
+// ignoreRule is a single pattern of an ignore file.
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool // The pattern starts with "!" and includes matching paths again
+	dirOnly bool // The pattern ends with "/" and only matches folders
+}
+
+// parseIgnoreRule parses a line of an ignore file. It returns false for blank
+// lines and comments.
+func parseIgnoreRule(line string) (ignoreRule, bool, error) {
+	var rule ignoreRule
+
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false, nil
+	}
+
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false, nil
+	}
+
+	// Patterns containing a slash are relative to the folder, others match at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	pattern, err := globRegexp(line, anchored)
+	if err != nil {
+		return rule, false, fmt.Errorf("invalid pattern %q: %w", line, err)
+	}
+	rule.pattern = pattern
+	return rule, true, nil
+}
+
+// globRegexp converts a gitignore style glob into a regular expression
+// matching slash separated relative paths, ignoring case if ignoreCase is set.
+func globRegexp(glob string, anchored bool) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	if ignoreCase {
+		expr.WriteString("(?i)")
+	}
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(glob); i++ {
+		switch char := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case char == '*':
+			expr.WriteString("[^/]*")
+		case char == '?':
+			expr.WriteString("[^/]")
+		case char == '\\' && i+1 < len(glob):
+			i++
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case char == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// parseIgnoreRules parses the patterns of an ignore file, one per line.
+func parseIgnoreRules(patterns []string) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	for _, line := range patterns {
+		rule, ok, err := parseIgnoreRule(line)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// readIgnoreFile returns the patterns of the ignore file in the folder, or
+// nothing if the folder has no ignore file.
+func readIgnoreFile(folder string) ([]string, error) {
+	file, err := os.Open(filepath.Join(folder, ignoreFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", ignoreFileName, err)
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		patterns = append(patterns, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ignoreFileName, err)
+	}
+	return patterns, nil
+}
+
+// parsePatternList splits a comma or line separated list of glob patterns.
+func parsePatternList(text string) []string {
+	var patterns []string
+	for _, pattern := range strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n'
+	}) {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// pathFilter decides which files of a folder are indexed, combining the
+// default patterns, the folder's ignore file and the patterns chosen when the
+// folder was selected.
+type pathFilter struct {
+	ignore  []ignoreRule // Later rules take precedence over earlier ones
+	include []ignoreRule // Files must match one of these, unless there are none
+}
+
+// newPathFilter returns the filter for a folder and its folder options.
+func newPathFilter(folder string, options folderOptions) (*pathFilter, error) {
+	ignoreFile, err := readIgnoreFile(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	// Excluding patterns chosen for the folder are applied last, so they always win
+	patterns := append(append(append([]string{}, defaultIgnorePatterns...), ignoreFile...), options.Exclude...)
+	ignore, err := parseIgnoreRules(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	include, err := parseIgnoreRules(options.Include)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pathFilter{ignore: ignore, include: include}, nil
+}
+
+// ignored reports whether a file or folder, given by its slash separated path
+// relative to the selected folder, is left out by the ignore patterns. Paths
+// inside an ignored folder are ignored as well.
+func (f *pathFilter) ignored(relPath string, isDir bool) bool {
+	parts := strings.Split(relPath, "/")
+	for i := range parts {
+		if f.ignoredEntry(strings.Join(parts[:i+1], "/"), isDir || i < len(parts)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredEntry applies the ignore patterns to a single path. The last
+// matching pattern decides.
+func (f *pathFilter) ignoredEntry(relPath string, isDir bool) bool {
+	ignored := false
+	for _, rule := range f.ignore {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.pattern.MatchString(relPath) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// included reports whether a file matches the include patterns.
+func (f *pathFilter) included(relPath string) bool {
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, rule := range f.include {
+		if !rule.dirOnly && rule.pattern.MatchString(relPath) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestDefaultIgnorePatterns(t *testing.T) {
+	filter, err := newPathFilter(t.TempDir(), folderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for relPath, want := range map[string]bool{
+		"manual.pdf":                false,
+		"docs/.git/config":          true,
+		"docs/~$report.docx":        true,
+		"docs/Thumbs.db":            true,
+		"node_modules/lib/index.js": true,
+		"__MACOSX/manual.pdf":       true,
+	} {
+		if got := filter.ignored(relPath, false); got != want {
+			t.Errorf("ignored(%q) = %v, want %v", relPath, got, want)
+		}
+	}
+}
+
+func TestIgnorePatternsMatchCaseWhereFileNamesDo(t *testing.T) {
+	previous := ignoreCase
+	t.Cleanup(func() { ignoreCase = previous })
+
+	for _, test := range []struct {
+		ignoreCase bool
+		want       bool
+	}{{false, false}, {true, true}} {
+		ignoreCase = test.ignoreCase
+		filter, err := newPathFilter(t.TempDir(), folderOptions{Exclude: []string{"*.log"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, relPath := range []string{"photos/THUMBS.DB", "Backup.BAK", "Node_Modules/x.js", "Machine.LOG"} {
+			if got := filter.ignored(relPath, false); got != test.want {
+				t.Errorf("ignoreCase %v: ignored(%q) = %v, want %v", test.ignoreCase, relPath, got, test.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// indexDir returns the directory where the folder indexes are stored.
+func indexDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate user config directory: %w", err)
+	}
+	return filepath.Join(configDir, "QueryForge", "indexes"), nil
+}
+
+// indexPath returns the location of the saved index for a folder. Indexes are
+// keyed by a hash of the folder's absolute path.
+func indexPath(folder string) (string, error) {
+	dir, err := indexDir()
+	if err != nil {
+		return "", err
+	}
+
+	absFolder, err := filepath.Abs(folder)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve folder %s: %w", folder, err)
+	}
+
+	sum := sha256.Sum256([]byte(absFolder))
+	return filepath.Join(dir, hex.EncodeToString(sum[:16])+".gob"), nil
+}
+
+// describeIndex returns a short description of the folder an index was built from.
+func describeIndex(index *vectorIndex) string {
+	return fmt.Sprintf("Folder: %s (%d files, %d chunks)", filepath.Base(index.Folder), len(index.Files), index.chunkCount())
+}
+
+// indexUpdate summarises the changes applied to an index.
+type indexUpdate struct {
+	Added, Changed, Removed, Unchanged int
+	Skipped                            int // Unsupported or unreadable files
+	OverLimit                          int // Files left out to stay within the indexing limits
+	Ignored                            int // Files and folders left out by the ignore and include patterns
+
+	Files []fileReport // What happened to each file found in the folder
+
+	touched bool // Set when the index changed without files being added, changed or removed
+}
+
+// changed reports whether the update modified the index.
+func (u indexUpdate) changed() bool {
+	return u.touched || u.Added+u.Changed+u.Removed > 0
+}
+
+// String returns a short human readable summary of the update.
+func (u indexUpdate) String() string {
+	summary := fmt.Sprintf("%d added, %d changed, %d removed, %d unchanged", u.Added, u.Changed, u.Removed, u.Unchanged)
+	if u.Skipped > 0 {
+		summary += fmt.Sprintf(", %d skipped", u.Skipped)
+	}
+	if u.OverLimit > 0 {
+		summary += fmt.Sprintf(", %d over limit", u.OverLimit)
+	}
+	if u.Ignored > 0 {
+		summary += fmt.Sprintf(", %d ignored", u.Ignored)
+	}
+	return summary
+}
+
+// folderOptions are the choices made when a folder is selected. They are
+// saved with the folder's index and applied whenever it is updated.
+type folderOptions struct {
+	Include []string // Glob patterns of the files to index, all supported files if empty
+	Exclude []string // Glob patterns of files and folders to leave out, in .gitignore syntax
+
+	PDFLayout bool // Rebuild the reading order of PDF pages from the text positions
+}
+
+// openFolderIndex loads the saved index for the folder, brings it up to date
+// with the files on disk and the folder options, and saves it again if
+// anything changed. The index becomes the one used for answering questions.
+func openFolderIndex(ctx context.Context, folder string, options folderOptions) (*vectorIndex, indexUpdate, error) {
+	// Refreshes waiting for the lock see this index, not the one it replaces
+	indexUpdateMutex.Lock()
+	defer indexUpdateMutex.Unlock()
+
+	index, err := loadIndex(folder)
+	if err != nil {
+		fmt.Println("Building a new index:", err)
+		index = &vectorIndex{Version: indexVersion, Folder: folder, EmbeddingModel: getEmbeddingModelName(), VisionModel: getVisionModelName()}
+	}
+	optionsChanged := !slices.Equal(index.Options.Include, options.Include) || !slices.Equal(index.Options.Exclude, options.Exclude) ||
+		index.Options.PDFLayout != options.PDFLayout
+	if index.Options.PDFLayout != options.PDFLayout {
+		// PDFs are extracted differently, so they are indexed again
+		index.Files = slices.DeleteFunc(index.Files, func(file indexedFile) bool {
+			return strings.EqualFold(path.Ext(file.Path), ".pdf")
+		})
+		index.Skipped = slices.DeleteFunc(index.Skipped, func(file skippedFile) bool {
+			return strings.EqualFold(path.Ext(file.Path), ".pdf")
+		})
+	}
+	index.Options = options
+
+	index, update, err := refreshIndex(ctx, index)
+	if err != nil {
+		return nil, update, err
+	}
+
+	// New options are saved even when they did not change which files are indexed
+	if optionsChanged && !update.changed() {
+		if err := saveIndex(index); err != nil {
+			fmt.Println("Error saving index:", err)
+		}
+	}
+
+	if index.chunkCount() == 0 {
+		return nil, update, fmt.Errorf("no text found in the selected folder")
+	}
+	setDocumentIndex(index)
+	return index, update, nil
+}
+
+// indexUpdateMutex ensures that only one update of an index runs at a time,
+// e.g. when the folder watcher fires while the folder is being selected again.
+// The index of the selected folder is only replaced while it is held.
+var indexUpdateMutex sync.Mutex
+
+// refreshIndex brings the index up to date with the files on disk and saves
+// it if anything changed. The caller holds indexUpdateMutex.
+func refreshIndex(ctx context.Context, index *vectorIndex) (*vectorIndex, indexUpdate, error) {
+	index, update, err := updateIndex(ctx, index)
+	if err != nil {
+		return nil, update, err
+	}
+	saveUpdatedIndex(index, update)
+	return index, update, nil
+}
+
+// saveUpdatedIndex saves the index if the update changed anything.
+func saveUpdatedIndex(index *vectorIndex, update indexUpdate) {
+	fmt.Printf("Updated index for %s: %s\n", index.Folder, update)
+
+	if update.changed() {
+		if err := saveIndex(index); err != nil {
+			// The index is still usable for this session
+			fmt.Println("Error saving index:", err)
+		}
+	}
+}
+
+// refreshDocumentIndex brings the index of the selected folder up to date,
+// e.g. after its files or the settings changed. It returns nil if the folder
+// is not selected, or if the index was replaced while it was refreshed, e.g.
+// when the folder was selected again with other options.
+func refreshDocumentIndex(ctx context.Context, folder string) (*vectorIndex, indexUpdate, error) {
+	indexUpdateMutex.Lock()
+	defer indexUpdateMutex.Unlock()
+
+	// The index is taken once the lock is held, so an index opened meanwhile is the one refreshed
+	index := getDocumentIndex()
+	if index == nil || index.Folder != folder {
+		return nil, indexUpdate{}, nil
+	}
+
+	updated, update, err := updateIndex(ctx, index)
+	if err != nil {
+		return nil, update, err
+	}
+
+	// The index may have been cleared meanwhile, e.g. when the embedding model changed
+	if !replaceDocumentIndex(index, updated) {
+		return nil, update, nil
+	}
+	saveUpdatedIndex(updated, update)
+	return updated, update, nil
+}
+
+// updateIndex returns a copy of the index that matches the files currently in
+// its folder. Files whose modification time and size are unchanged are reused
+// as they are, and files whose contents hash is unchanged keep their chunks.
+// Only new and modified files are extracted and embedded again. Files that can
+// not be read or are beyond the indexing limits are left out and reported,
+// instead of failing the whole folder. Files that could not be read or did not
+// fit are kept in the index, so they are not read again until their contents
+// change, or until the limit they ran into is raised. When the vision model
+// changed, images are described again by the new model, or removed if there is
+// none.
+func updateIndex(ctx context.Context, index *vectorIndex) (*vectorIndex, indexUpdate, error) {
+	var update indexUpdate
+	limits := getIndexLimits()
+	visionModel := getVisionModelName()
+	describeImages := index.VisionModel != visionModel
+
+	listing, err := listFolderFiles(index.Folder, index.Options, limits)
+	if err != nil {
+		return nil, update, err
+	}
+	update.Ignored = listing.Ignored
+
+	// Look up the previously indexed files by path
+	previous := make(map[string]indexedFile, len(index.Files))
+	for _, file := range index.Files {
+		previous[file.Path] = file
+	}
+
+	// skip leaves a file out of the index and reports why
+	skip := func(report fileReport, known bool) {
+		if report.Status == fileOverLimit {
+			update.OverLimit++
+		} else {
+			update.Skipped++
+		}
+		update.Files = append(update.Files, report)
+		if known {
+			update.touched = true
+		}
+	}
+	for _, report := range listing.Skipped {
+		_, known := previous[report.Path]
+		delete(previous, report.Path)
+		skip(report, known)
+	}
+
+	// Files that could not be indexed before are not read again until their contents change
+	previousSkipped := make(map[string]skippedFile, len(index.Skipped))
+	for _, file := range index.Skipped {
+		previousSkipped[file.Path] = file
+	}
+
+	// Files whose modification time or size changed are read again, in parallel
+	var jobs []extractJob
+	for _, file := range listing.Files {
+		if describeImages && isImageFile(file.RelPath) {
+			// Without the old hash the image is described even if it did not change
+			jobs = append(jobs, extractJob{file: file})
+			continue
+		}
+		old, known := previous[file.RelPath]
+		if skipped, ok := previousSkipped[file.RelPath]; !known && ok {
+			if !skipped.unchanged(file) {
+				jobs = append(jobs, extractJob{file: file, oldHash: skipped.Hash})
+			} else if skipped.Timeout > 0 && !skipped.timedOutWithin(limits.FileTimeout) {
+				// The file timeout was raised, so it may be read in time now
+				jobs = append(jobs, extractJob{file: file})
+			}
+			continue
+		}
+		if !known || !old.ModTime.Equal(file.ModTime) || old.Size != file.Size {
+			jobs = append(jobs, extractJob{file: file, oldHash: old.Hash})
+		}
+	}
+	extractions := make(map[string]extraction, len(jobs))
+	for i, result := range extractFiles(ctx, jobs, index.Options, limits) {
+		extractions[jobs[i].file.RelPath] = result
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, update, err
+	}
+
+	updated := &vectorIndex{Version: index.Version, Folder: index.Folder, EmbeddingModel: index.EmbeddingModel, VisionModel: visionModel, Options: index.Options}
+	if describeImages {
+		update.touched = true
+	}
+	characters := 0  // Characters of the files indexed so far
+	keptSkipped := 0 // Skipped files reused from the index
+
+	// skipOverLimit leaves out a file whose text does not fit, remembering how much text it has
+	skipOverLimit := func(file folderFile, hash string, err error, fileCharacters int, known bool) {
+		report := overLimitFileReport(file, err)
+		skip(report, known)
+		record := skippedFileRecord(file, hash, report)
+		record.Characters = fileCharacters
+		updated.Skipped = append(updated.Skipped, record)
+	}
+	for _, file := range listing.Files {
+		old, known := previous[file.RelPath]
+		delete(previous, file.RelPath)
+
+		extracted, read := extractions[file.RelPath]
+		if !known && (!read || extracted.sameHash) {
+			// Skipped before and unchanged since
+			skipped := previousSkipped[file.RelPath]
+			if read {
+				// Only the metadata changed, e.g. the file was touched or copied
+				update.touched = true
+				skipped.ModTime, skipped.Size = file.ModTime, file.Size
+			}
+			if skipped.Status != fileOverLimit || limits.checkCharacters(characters, skipped.Characters) != nil {
+				skip(recordedFileReport(skipped), false)
+				updated.Skipped = append(updated.Skipped, skipped)
+				keptSkipped++
+				continue
+			}
+			// Other files made room for it, so it is read after all
+			extracted, read = extractFiles(ctx, []extractJob{{file: file}}, index.Options, limits)[0], true
+			if err := ctx.Err(); err != nil {
+				return nil, update, err
+			}
+		}
+		if read && errors.Is(extracted.err, errVisionModel) {
+			// Like embedding errors, the image is read again once the vision model works
+			return nil, update, extracted.err
+		}
+		if read && extracted.err != nil {
+			report := skippedFileReport(file, extracted.err, extracted.duration)
+			skip(report, known)
+			record := skippedFileRecord(file, extracted.doc.Hash, report)
+			if errors.Is(extracted.err, errExtractionTimedOut) {
+				record.Timeout = limits.FileTimeout
+			}
+			updated.Skipped = append(updated.Skipped, record)
+			continue
+		}
+
+		if !read || extracted.sameHash {
+			if read {
+				// Only the metadata changed, e.g. the file was touched or copied
+				update.touched = true
+				old.ModTime, old.Size = file.ModTime, file.Size
+			}
+			if err := limits.checkCharacters(characters, old.Characters); err != nil {
+				skipOverLimit(file, old.Hash, err, old.Characters, known)
+				continue
+			}
+			characters += old.Characters
+			update.Unchanged++
+			update.Files = append(update.Files, unchangedFileReport(old))
+			updated.Files = append(updated.Files, old)
+			continue
+		}
+
+		doc := extracted.doc
+		if err := limits.checkCharacters(characters, doc.characters()); err != nil {
+			skipOverLimit(file, doc.Hash, err, doc.characters(), known)
+			continue
+		}
+		characters += doc.characters()
+
+		// Embedding errors are not specific to the file, e.g. when Ollama is not running
+		started := time.Now()
+		indexed, err := embedDocument(ctx, doc)
+		if err != nil {
+			return nil, update, err
+		}
+		updated.Files = append(updated.Files, indexed)
+		update.Files = append(update.Files, fileReport{
+			Path:     file.RelPath,
+			Status:   fileIndexed,
+			Bytes:    file.Size,
+			Pages:    doc.Pages,
+			Duration: extracted.duration + time.Since(started),
+		})
+
+		if known {
+			update.Changed++
+		} else {
+			update.Added++
+		}
+	}
+
+	// Whatever is left was removed from the folder
+	update.Removed = len(previous)
+
+	// Newly skipped files and skipped files that were fixed or removed are saved as well
+	if len(updated.Skipped) != keptSkipped || len(index.Skipped) != keptSkipped {
+		update.touched = true
+	}
+
+	return updated, update, nil
+}
+
+// embedDocument chunks the text of a document and embeds every chunk.
+func embedDocument(ctx context.Context, doc document) (indexedFile, error) {
+	file := indexedFile{Path: doc.RelPath, ModTime: doc.ModTime, Size: doc.Size, Hash: doc.Hash, Pages: doc.Pages, Characters: doc.characters()}
+
+	// Chunk each section on its own, so no chunk spans two sections
+	var chunks []indexedChunk
+	var texts []string
+	for _, section := range doc.Sections {
+		for _, text := range chunkText(section.Text, chunkSize, chunkOverlap) {
+			chunks = append(chunks, indexedChunk{Source: doc.RelPath, Location: section.Location, Text: text})
+			texts = append(texts, text)
+		}
+	}
+	if len(texts) == 0 {
+		return file, nil
+	}
+
+	embeddings, err := embedTexts(ctx, texts)
+	if err != nil {
+		return file, fmt.Errorf("failed to embed %s: %w", doc.RelPath, err)
+	}
+
+	for i := range chunks {
+		chunks[i].Embedding = embeddings[i]
+	}
+	file.Chunks = chunks
+	return file, nil
+}
+
+// saveIndex writes the index to disk, replacing any previously saved index
+// for the same folder.
+func saveIndex(index *vectorIndex) error {
+	path, err := indexPath(index.Folder)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated index behind
+	tempFile, err := os.CreateTemp(filepath.Dir(path), "index_*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create index file: %w", err)
+	}
+
+	if err := gob.NewEncoder(tempFile).Encode(index); err != nil {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	if err := tempFile.Close(); err != nil {
+		_ = os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to close index file: %w", err)
+	}
+
+	if err := os.Rename(tempFile.Name(), path); err != nil {
+		_ = os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to save index: %w", err)
+	}
+	return nil
+}
+
+// loadIndex reads the saved index for the folder. Indexes built with a
+// different embedding model are rejected, as their vectors can not be compared,
+// and so are indexes built by older versions of the extractors.
+func loadIndex(folder string) (*vectorIndex, error) {
+	path, err := indexPath(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no saved index for %s", folder)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+	defer file.Close()
+
+	var index vectorIndex
+	if err := gob.NewDecoder(file).Decode(&index); err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	if index.Version != indexVersion {
+		return nil, fmt.Errorf("saved index was built by an older version of QueryForge")
+	}
+	if index.EmbeddingModel != getEmbeddingModelName() {
+		return nil, fmt.Errorf("saved index was built with %s, not %s", index.EmbeddingModel, getEmbeddingModelName())
+	}
+	return &index, nil
+}
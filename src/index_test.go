@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// useFakeEmbeddings answers embedding requests with a fixed vector per text.
+func useFakeEmbeddings(t *testing.T) {
+	t.Helper()
+	useFakeOllama(t, answerEmbeddings)
+}
+
+// answerEmbeddings answers an embedding request with a fixed vector per text.
+func answerEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var req api.EmbedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	inputs, _ := req.Input.([]interface{})
+	resp := api.EmbedResponse{Model: req.Model}
+	for range inputs {
+		resp.Embeddings = append(resp.Embeddings, []float32{1, 0, 0})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// useTempConfigDir saves indexes in a temporary directory for the duration of a test.
+func useTempConfigDir(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("AppData", dir)
+}
+
+// writeTestFiles creates a folder holding the given files.
+func writeTestFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	folder := t.TempDir()
+	for name, content := range files {
+		filePath := filepath.Join(folder, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return folder
+}
+
+// indexedPaths returns the paths of the files in the index.
+func indexedPaths(index *vectorIndex) []string {
+	var paths []string
+	for _, file := range index.Files {
+		paths = append(paths, file.Path)
+	}
+	return paths
+}
+
+func TestRemovedPlainTextExtensionIsEvicted(t *testing.T) {
+	useFakeEmbeddings(t)
+	useTempConfigDir(t)
+	t.Cleanup(func() { setPlainTextExtensions(defaultPlainTextExtensions) })
+	t.Cleanup(func() { setDocumentIndex(nil) })
+
+	folder := writeTestFiles(t, map[string]string{
+		"notes.txt":   "Felt tension is 4.5 kN/m.",
+		"machine.log": "Sheet break at 10:42.",
+	})
+	index := &vectorIndex{Version: indexVersion, Folder: folder, EmbeddingModel: getEmbeddingModelName()}
+
+	index, update, err := updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.Added != 2 {
+		t.Fatalf("update = %s, want 2 added", update)
+	}
+
+	// Saving the settings refreshes the selected folder
+	setDocumentIndex(index)
+	setPlainTextExtensions([]string{".txt"})
+	_, update, err = refreshDocumentIndex(context.Background(), folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index = getDocumentIndex()
+	if paths := indexedPaths(index); len(paths) != 1 || paths[0] != "notes.txt" {
+		t.Errorf("indexed files = %v, want only notes.txt", paths)
+	}
+	if !update.changed() || update.Skipped != 1 {
+		t.Errorf("update = %s, want the log file skipped and the index changed", update)
+	}
+}
+
+func TestFailedFileIsNotReadAgainUntilItChanges(t *testing.T) {
+	useFakeEmbeddings(t)
+	folder := writeTestFiles(t, map[string]string{
+		"notes.txt":  "Felt tension is 4.5 kN/m.",
+		"broken.pdf": "not a PDF",
+	})
+	index := &vectorIndex{Version: indexVersion, Folder: folder, EmbeddingModel: getEmbeddingModelName()}
+
+	index, update, err := updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Skipped) != 1 || index.Skipped[0].Path != "broken.pdf" || index.Skipped[0].Status != fileFailed || index.Skipped[0].Hash == "" {
+		t.Fatalf("skipped files = %+v, want broken.pdf", index.Skipped)
+	}
+	if !update.changed() {
+		t.Error("the failed file was not saved with the index")
+	}
+
+	// The failure is reported again without reading the file
+	index, update, err = updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.changed() || update.Skipped != 1 {
+		t.Errorf("update = %s, want nothing changed and 1 skipped", update)
+	}
+	for _, report := range update.Files {
+		if report.Path == "broken.pdf" && (report.Status != fileFailed || report.Reason == "" || report.Duration != 0) {
+			t.Errorf("report = %+v, want the recorded failure", report)
+		}
+	}
+
+	// A touched file is only read again if its contents changed
+	brokenPath := filepath.Join(folder, "broken.pdf")
+	modTime := time.Now().Add(time.Minute)
+	if err := os.Chtimes(brokenPath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	index, update, err = updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !update.changed() || len(index.Skipped) != 1 || !index.Skipped[0].ModTime.Equal(modTime) {
+		t.Errorf("update = %s, skipped files = %+v, want the new modification time recorded", update, index.Skipped)
+	}
+	for _, report := range update.Files {
+		if report.Path == "broken.pdf" && report.Duration != 0 {
+			t.Errorf("report = %+v, want the recorded failure", report)
+		}
+	}
+
+	hash := index.Skipped[0].Hash
+	if err := os.WriteFile(brokenPath, []byte("still not a PDF"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	index, update, err = updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !update.changed() || len(index.Skipped) != 1 || index.Skipped[0].Hash == hash {
+		t.Errorf("update = %s, skipped files = %+v, want the failure recorded again", update, index.Skipped)
+	}
+
+	// The record goes when the file does
+	if err := os.Remove(brokenPath); err != nil {
+		t.Fatal(err)
+	}
+	index, update, err = updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !update.changed() || len(index.Skipped) != 0 {
+		t.Errorf("update = %s, skipped files = %+v, want the record removed", update, index.Skipped)
+	}
+}
+
+func TestFileOverCharacterLimitIsReadWhenItFits(t *testing.T) {
+	useFakeEmbeddings(t)
+	previousLimits := getIndexLimits()
+	t.Cleanup(func() { setIndexLimits(previousLimits) })
+
+	limits := defaultIndexLimits()
+	limits.MaxCharacters = 20
+	setIndexLimits(limits)
+
+	folder := writeTestFiles(t, map[string]string{
+		"a.txt": "Short note",
+		"b.txt": "A note that is too long to fit",
+	})
+	index := &vectorIndex{Version: indexVersion, Folder: folder, EmbeddingModel: getEmbeddingModelName()}
+
+	index, _, err := updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Skipped) != 1 || index.Skipped[0].Status != fileOverLimit || index.Skipped[0].Characters != 31 {
+		t.Fatalf("skipped files = %+v, want b.txt with its 31 characters", index.Skipped)
+	}
+
+	// Unchanged, it still does not fit and is not read again
+	index, update, err := updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.changed() || update.OverLimit != 1 {
+		t.Errorf("update = %s, want nothing changed and 1 over limit", update)
+	}
+
+	// With a higher limit it is indexed
+	limits.MaxCharacters = 100
+	setIndexLimits(limits)
+	index, update, err = updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.Added != 1 || len(index.Files) != 2 || len(index.Skipped) != 0 {
+		t.Errorf("update = %s, skipped files = %+v, want b.txt added", update, index.Skipped)
+	}
+}
+
+func TestTimedOutFileIsReadWhenTheTimeoutIsRaised(t *testing.T) {
+	useFakeEmbeddings(t)
+	previousLimits := getIndexLimits()
+	t.Cleanup(func() { setIndexLimits(previousLimits) })
+
+	limits := defaultIndexLimits()
+	limits.FileTimeout = time.Nanosecond
+	setIndexLimits(limits)
+
+	folder := writeTestFiles(t, map[string]string{
+		"large.txt": strings.Repeat("Felt tension is 4.5 kN/m.\n", 1<<18),
+	})
+	index := &vectorIndex{Version: indexVersion, Folder: folder, EmbeddingModel: getEmbeddingModelName()}
+
+	index, _, err := updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Skipped) != 1 || index.Skipped[0].Timeout != time.Nanosecond {
+		t.Fatalf("skipped files = %+v, want large.txt with its timeout", index.Skipped)
+	}
+
+	// With the same timeout it is not read again
+	index, update, err := updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.changed() || update.Skipped != 1 {
+		t.Errorf("update = %s, want nothing changed and 1 skipped", update)
+	}
+
+	// With a longer timeout it is indexed
+	limits.FileTimeout = time.Minute
+	setIndexLimits(limits)
+	index, update, err = updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.Added != 1 || len(index.Files) != 1 || len(index.Skipped) != 0 {
+		t.Errorf("update = %s, skipped files = %+v, want large.txt added", update, index.Skipped)
+	}
+}
+
+func TestRefreshUsesTheIndexOpenedLast(t *testing.T) {
+	useFakeEmbeddings(t)
+	useTempConfigDir(t)
+	t.Cleanup(func() { setDocumentIndex(nil) })
+
+	folder := writeTestFiles(t, map[string]string{
+		"notes.txt": "Felt tension is 4.5 kN/m.",
+		"draft.txt": "Unchecked readings.",
+	})
+	if _, _, err := openFolderIndex(context.Background(), folder, folderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Selecting the folder again with other options replaces the index a refresh starts from
+	options := folderOptions{Exclude: []string{"draft.txt"}}
+	opened, _, err := openFolderIndex(context.Background(), folder, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getDocumentIndex() != opened {
+		t.Fatal("the opened index is not the one used for answering questions")
+	}
+
+	updated, _, err := refreshDocumentIndex(context.Background(), folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated == nil || !slices.Equal(updated.Options.Exclude, options.Exclude) || !slices.Equal(indexedPaths(updated), []string{"notes.txt"}) {
+		t.Fatalf("refreshed index = %+v, want the options it was opened with", updated)
+	}
+	saved, err := loadIndex(folder)
+	if err != nil || !slices.Equal(saved.Options.Exclude, options.Exclude) {
+		t.Errorf("saved index = %+v, %v, want the options it was opened with", saved, err)
+	}
+}
+
+func TestRefreshIsDroppedWhenTheIndexIsReplaced(t *testing.T) {
+	useTempConfigDir(t)
+	t.Cleanup(func() { setDocumentIndex(nil) })
+
+	// The index is replaced while the refresh embeds the new file
+	var replace func()
+	useFakeOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		if replace != nil {
+			replace()
+			replace = nil
+		}
+		answerEmbeddings(w, r)
+	})
+
+	folder := writeTestFiles(t, map[string]string{"notes.txt": "Felt tension is 4.5 kN/m."})
+	if _, _, err := openFolderIndex(context.Background(), folder, folderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(folder, "new.txt"), []byte("Sheet break at 10:42."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	replacement := &vectorIndex{Version: indexVersion, Folder: folder, Options: folderOptions{PDFLayout: true}}
+	replace = func() { setDocumentIndex(replacement) }
+	updated, _, err := refreshDocumentIndex(context.Background(), folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != nil || getDocumentIndex() != replacement {
+		t.Errorf("refreshed index = %+v, want the replacement kept", updated)
+	}
+	if saved, err := loadIndex(folder); err != nil || len(saved.Files) != 1 {
+		t.Errorf("saved index = %+v, %v, want the refresh not saved", saved, err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// indexLimits bounds how much of a folder is indexed, so large document trees
+// can be selected without indexing taking hours. Files beyond the limits are
+// skipped and reported. A limit of 0 means no limit.
+type indexLimits struct {
+	MaxFiles      int   // Supported files indexed per folder
+	MaxFileSize   int64 // Size of a single file, in bytes
+	MaxCharacters int   // Text extracted from all files together
+	MaxDepth      int   // Levels of subfolders below the selected folder
+
+	Workers     int           // Files extracted in parallel, 0 for one per CPU
+	FileTimeout time.Duration // Time allowed to extract a single file
+}
+
+// defaultIndexLimits returns the limits used until they are changed in the settings.
+func defaultIndexLimits() indexLimits {
+	return indexLimits{
+		MaxFiles:      1000,
+		MaxFileSize:   50 * 1000 * 1000,
+		MaxCharacters: 20 * 1000 * 1000,
+		MaxDepth:      10,
+		Workers:       0,
+		FileTimeout:   2 * time.Minute,
+	}
+}
+
+var (
+	currentIndexLimits = defaultIndexLimits()
+	indexLimitsMutex   sync.RWMutex
+)
+
+// setIndexLimits sets the limits applied when a folder is indexed.
+func setIndexLimits(limits indexLimits) {
+	indexLimitsMutex.Lock()
+	defer indexLimitsMutex.Unlock()
+	currentIndexLimits = limits
+}
+
+// getIndexLimits returns the limits applied when a folder is indexed.
+func getIndexLimits() indexLimits {
+	indexLimitsMutex.RLock()
+	defer indexLimitsMutex.RUnlock()
+	return currentIndexLimits
+}
+
+// checkFile returns why a file can not be indexed after count files were
+// found already, or nil if it is within the limits.
+func (l indexLimits) checkFile(count int, size int64) error {
+	if l.MaxFiles > 0 && count >= l.MaxFiles {
+		return fmt.Errorf("file limit of %d files reached", l.MaxFiles)
+	}
+	if l.MaxFileSize > 0 && size > l.MaxFileSize {
+		return fmt.Errorf("larger than the %s file size limit", formatBytes(l.MaxFileSize))
+	}
+	return nil
+}
+
+// checkDepth returns why a folder at the given depth below the selected
+// folder is not indexed, or nil if it is within the limits.
+func (l indexLimits) checkDepth(depth int) error {
+	if l.MaxDepth > 0 && depth > l.MaxDepth {
+		return fmt.Errorf("nested deeper than %d folders", l.MaxDepth)
+	}
+	return nil
+}
+
+// checkCharacters returns why a file with the given number of characters can
+// not be indexed after total characters were indexed already, or nil if it is
+// within the limits.
+func (l indexLimits) checkCharacters(total, characters int) error {
+	if l.MaxCharacters > 0 && total+characters > l.MaxCharacters {
+		return fmt.Errorf("total text limit of %d characters reached", l.MaxCharacters)
+	}
+	return nil
+}
@@ -5,9 +5,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"os"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -18,12 +18,28 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
+// Preference keys used to remember state between sessions
+const (
+	lastFolderPreference     = "lastFolder"
+	watchFolderPreference    = "watchFolder"
+	embeddingModelPreference = "embeddingModel"
+)
+
 func main() {
 	// Create a new Fyne application
 	a := app.NewWithID("ValmetQueryForge")
 	w := a.NewWindow("Valmet QueryForge")
 	w.Resize(fyne.NewSize(200, 500)) // Edit this line to change the window size: width x height (pixels)
 
+	// Connect to the Ollama server saved in the settings
+	setOllamaConnection(loadOllamaConnection(a.Preferences()))
+	loadIndexingSettings(a.Preferences())
+
+	// Saved indexes can only be reloaded with the embedding model they were built with
+	if model := a.Preferences().String(embeddingModelPreference); model != "" {
+		setEmbeddingModelName(model)
+	}
+
 	// Load the Valmet logo image from a static resource
 	image := canvas.NewImageFromResource(resourceValmetlogosmallPng)
 	image.FillMode = canvas.ImageFillOriginal
@@ -42,73 +58,139 @@ func main() {
 	scrollOutput := container.NewVScroll(output)
 	scrollOutput.SetMinSize(fyne.NewSize(380, 200)) // Sinimum size for the scroll area
 
-	// Progress bar to show the AI query progress
-	progress := widget.NewProgressBar()
-	progress.Hide()
+	// Label to show which folder is used for the RAG search
+	indexStatus := widget.NewLabel("No folder selected.")
+	indexStatus.Wrapping = fyne.TextWrapWord
+
+	// Keep the index up to date in the background while files in the folder change
+	startWatching := func(folder string) {
+		if !a.Preferences().Bool(watchFolderPreference) {
+			return
+		}
+		if err := watchFolder(folder, indexStatus.SetText); err != nil {
+			fmt.Println("Error watching folder:", err)
+			indexStatus.SetText(fmt.Sprintf("%s\nNot watching for changes: %v", indexStatus.Text, err))
+		}
+	}
+
+	// Checkbox to turn the folder watcher on and off
+	watchCheck := widget.NewCheck("Watch folder for changes", func(checked bool) {
+		a.Preferences().SetBool(watchFolderPreference, checked)
+		if !checked {
+			stopWatching()
+			return
+		}
+		if index := getDocumentIndex(); index != nil {
+			startWatching(index.Folder)
+		}
+	})
+	watchCheck.SetChecked(a.Preferences().Bool(watchFolderPreference))
+
+	// Reload the index of the folder selected in the last session
+	if folder := a.Preferences().String(lastFolderPreference); folder != "" {
+		go func() {
+			index, err := loadIndex(folder)
+			if err != nil {
+				fmt.Println("Saved index not loaded:", err)
+				return
+			}
+			// A folder selected while the index was loading is kept
+			if !replaceDocumentIndex(nil, index) {
+				return
+			}
+			indexStatus.SetText(describeIndex(index))
+			startWatching(folder)
+		}()
+	}
+
+	// Live indicator to show what the AI query is doing
+	activity := widget.NewActivity()
+	activityLabel := widget.NewLabel("")
+	indicator := container.NewCenter(container.NewHBox(activity, activityLabel))
+	indicator.Hide()
+
+	showActivity := func(status string) {
+		activityLabel.SetText(status)
+		activity.Start()
+		indicator.Show()
+	}
+	hideActivity := func() {
+		activity.Stop()
+		indicator.Hide()
+	}
+
+	// Conversation with the AI, so follow-up questions can refer to earlier answers
+	chat := newConversation(historyTokenBudget)
+
+	// Cancels the query that is currently running
+	cancelQuery := context.CancelFunc(func() {})
+
+	// Stop button to cancel the query - only enabled while a query is running
+	stopButton := widget.NewButtonWithIcon("Stop", theme.MediaStopIcon(), func() {
+		cancelQuery()
+	})
+	stopButton.Disable()
 
 	// Ask button to query the AI
-	askButton := widget.NewButton("Query the AI", func() {
+	var askButton *widget.Button
+	askButton = widget.NewButton("Query the AI", func() {
 		question := input.Text
 		if question == "" {
 			output.SetText("Please enter a question.")
 			return
 		}
 
-		// Show the progress bar and set the value to 0
-		progress.Show()
-		progress.SetValue(0) // Start the progress bar at 0
+		// Only one query can run at a time
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelQuery = cancel
+		askButton.Disable()
+		stopButton.Enable()
 
-		// Start a goroutine to query the AI and update the progress bar
+		showActivity("Searching documents...")
+
+		// Start a goroutine to query the AI and stream the answer into the output
 		// Note: This allows the UI to remain responsive while the AI is processing the question,
 		// thanks to multi-threading built into Go.
 		go func() {
-			progress.SetValue(0.25)
-
-			var question string
+			defer func() {
+				cancel()
+				hideActivity()
+				stopButton.Disable()
+				askButton.Enable()
+			}()
 
-			if tempFileLocation != "" {
-				// Try to open and read the file
-				file, err := os.Open(tempFileLocation)
-				if err != nil {
-					output.SetText(fmt.Sprintf("Error opening file: %v", err))
-					progress.Hide()
+			// Retrieve the chunks most relevant to the question, if a folder is selected
+			var chunks []indexedChunk
+			if index := getDocumentIndex(); index != nil {
+				var err error
+				chunks, err = index.search(ctx, question, topK)
+				if ctx.Err() != nil {
+					output.SetText("Query stopped.")
 					return
 				}
-				defer file.Close()
-
-				// Read the file content
-				content, err := io.ReadAll(file)
 				if err != nil {
-					output.SetText(fmt.Sprintf("Error reading file: %v", err))
-					progress.Hide()
-					return
-				}
-
-				// Construct the question with "CONTENT:" prefix
-				question = "CONTENT:\n" + string(content)
-			} else {
-				// No document specified, just use user input
-				question = input.Text
-				if question == "" {
-					output.SetText("Please enter a question or specify a document.")
-					progress.Hide()
+					output.SetText(fmt.Sprintf("Error searching documents: %v", err))
 					return
 				}
 			}
 
-			progress.SetValue(0.5)
-
-			// Call the AI API
-			Response, err := talkToOllama(question)
-			if err != nil {
+			showActivity("Generating...")
+			output.SetText("")
+
+			// Call the AI API with the question and the retrieved document content,
+			// showing each token as soon as it arrives
+			answer := &strings.Builder{}
+			response, err := talkToOllama(ctx, chat, question, chunks, func(token string) {
+				answer.WriteString(token)
+				output.SetText(answer.String())
+				scrollOutput.ScrollToBottom()
+			})
+			if ctx.Err() != nil {
+				// Keep the partial answer, marked as interrupted
+				output.SetText(response)
+			} else if err != nil {
 				output.SetText(fmt.Sprintf("Error: %v", err))
-			} else {
-				output.SetText(Response)
 			}
-
-			// Update and hide progress bar
-			progress.SetValue(1.0)
-			progress.Hide()
 		}()
 
 	})
@@ -118,31 +200,42 @@ func main() {
 		dialog.ShowInformation("About", "QueryForge \n by VII @ Valmet, Inc.\n\nA lightweight app for edge device RAG document searches.\n\nBuilt with ❤️ by Valmet USA - Atlanta, Georgia.", w)
 	})
 
-	// Settings button with menu containing checkboxes - Not yet functional
+	// Settings button with the AI models and the Ollama connection
 	settingsButton := widget.NewButtonWithIcon("Settings", theme.SettingsIcon(), func() {
 
 		// Select base conversational model for the AI - selected 1b by default
 		pickBaseModel := widget.NewLabel("Base AI Model:")
 
-		// Select the model from the dropdown
-		selectModel := widget.NewSelect([]string{"qwen2.5:0.5b", "llama3.2:1b", "llama3.2:3b", "phi3:3.8b"}, func(selected string) {
-			fmt.Println("Selected model:", selected)
-			setOllamaModelName(selected)
+		// Select the model from those installed on the Ollama server
+		selectModel := newModelSettings()
+
+		// Select the embedding model for the AI - selected 33m by default
+		pickEmbeddingModel := widget.NewLabel("Embedding Model:")
+		selectEmbeddingModel := widget.NewSelect([]string{"all-minilm:33m", "all-minilm:22m"}, func(selected string) {
+			fmt.Println("Selected embedding model:", selected)
+			if selected != getEmbeddingModelName() {
+				// Embeddings from different models can not be compared, so the folder must be indexed again
+				setEmbeddingModelName(selected)
+				a.Preferences().SetString(embeddingModelPreference, selected)
+				setDocumentIndex(nil)
+				stopWatching()
+				indexStatus.SetText("No folder selected.")
+			}
 		})
-
-		// // Select the embedding model for the AI - selected 33m by default
-		// pickEmbeddingModel := widget.NewLabel("Embedding Model:")
-		// selectEmbeddingModel := widget.NewSelect([]string{"all-minilm:33m", "all-minilm:22m"}, func(selected string) {
-		// 	fmt.Println("Selected embedding model:", selected)
-		// 	setEmbeddingModelName(selected)
-		// })
+		selectEmbeddingModel.SetSelected(getEmbeddingModelName())
 
 		// Function to set the AI model from user preferences
 		settingsMenu := container.NewVBox(
 			pickBaseModel,
 			selectModel,
-			// pickEmbeddingModel,
-			// selectEmbeddingModel,
+			pickEmbeddingModel,
+			selectEmbeddingModel,
+			widget.NewSeparator(),
+			widget.NewLabel("Indexing:"),
+			newIndexingSettings(a.Preferences()),
+			widget.NewSeparator(),
+			widget.NewLabel("Ollama Connection:"),
+			newConnectionSettings(a.Preferences()),
 		)
 
 		// Show the settings menu with the selected AI models
@@ -151,7 +244,7 @@ func main() {
 	})
 
 	// Folder picker for selecting a directory to run the RAG search within
-	folderPicker := widget.NewButton("Select Folder \n (PDF, TXT Formats Only)", func() {
+	folderPicker := widget.NewButton("Select Folder \n (PDF, Office, Spreadsheet, HTML, Markdown and Text Files)", func() {
 		dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
 			if err != nil {
 				fmt.Println("Error opening folder:", err)
@@ -164,39 +257,41 @@ func main() {
 			// Start the chunking process for the RAG search
 			fmt.Println("Selected folder:", uri.String())
 
-			// Start a goroutine to scan the directory and merge the files
-			go func() {
-				// Show a dialog to inform the user that the files are being processed
-				dialog.ShowInformation("Processing Files", "This may take a while - please wait...", w)
-
-				// Call mergeFilesToTemp and handle the result
-				tempFileLocation, err := mergeFilesToTemp(uri.Path())
-				if err != nil {
-					dialog.ShowError(err, w)
-					return
-				}
-
-				// Set the temporary file location for the AI query
-				setTempFileLocation(tempFileLocation)
-
-				// Notify the user of success and provide the location of the temporary file
-				dialog.ShowInformation("Files Processed", fmt.Sprintf("Files processed successfully."), w)
-
-				// // Clean up the temporary file after use
-				// err = deleteTempFile(tempFileLocation)
-				// if err != nil {
-				// 	dialog.ShowError(err, w)
-				// } else {
-				// 	fmt.Println("Temporary file deleted successfully.")
-				// }
-			}()
+			// Ask which files of the folder to index before indexing it
+			showFolderOptions(uri.Path(), a.Preferences(), w, func(options folderOptions) {
+				// Start a goroutine to scan the directory and index the files
+				go func() {
+					// Show a dialog to inform the user that the files are being processed
+					dialog.ShowInformation("Processing Files", "This may take a while - please wait...", w)
+
+					// Load the saved index for the folder and index any files that changed since
+					index, update, err := openFolderIndex(context.Background(), uri.Path(), options)
+					if err != nil {
+						dialog.ShowError(err, w)
+						if len(update.Files) > 0 {
+							// Show which files could not be read, as they may explain the error
+							showIngestionReport("Files Not Processed", update, w)
+						}
+						return
+					}
+
+					// Remember the folder so its index is reloaded on the next start
+					a.Preferences().SetString(lastFolderPreference, uri.Path())
+					indexStatus.SetText(describeIndex(index))
+					startWatching(uri.Path())
+
+					// Notify the user of success, with what happened to each file
+					showIngestionReport("Files Processed", update, w)
+				}()
+			})
 		}, w)
 	})
 
-	// Reset button to clear all text fields
+	// Reset button to clear all text fields and start a new conversation
 	resetButton := widget.NewButton("Clear All", func() {
 		input.SetText("")
 		output.SetText("")
+		chat.reset()
 	})
 
 	// Toolbar with copy, and paste actions
@@ -219,9 +314,12 @@ func main() {
 			container.NewHBox(
 				folderPicker,
 				askButton,
+				stopButton,
 			),
 		),
-		progress,
+		indexStatus,
+		container.NewCenter(watchCheck),
+		indicator,
 		container.NewCenter(
 			container.NewHBox(
 				toolbar,
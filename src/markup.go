@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// appendHtmlFileContents appends the text of an HTML page, keeping headings,
+// link text, table rows and code blocks but dropping the markup.
+func appendHtmlFileContents(w io.Writer, filePath string) error {
+	file, err := openSourceFile(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	root, err := html.Parse(file)
+	if err != nil {
+		return fmt.Errorf("failed to parse HTML file %s: %w", filePath, err)
+	}
+
+	out := &htmlText{w: w}
+	out.walk(root)
+	out.endLine()
+	return out.err
+}
+
+// htmlText writes the text of an HTML tree, one block element per line.
+type htmlText struct {
+	w    io.Writer
+	line strings.Builder
+	row  []string // Cells of the current table row
+	err  error    // First error returned by the writer
+}
+
+// write writes a line of output, remembering the first error.
+func (h *htmlText) write(line string) {
+	if h.err == nil {
+		_, h.err = io.WriteString(h.w, line+"\n")
+	}
+}
+
+// endLine writes the text collected for the current block, if any.
+func (h *htmlText) endLine() {
+	// Collapse the whitespace used to indent the markup
+	if text := strings.Join(strings.Fields(h.line.String()), " "); text != "" {
+		h.write(text)
+	}
+	h.line.Reset()
+}
+
+// walk writes the text of a node and its children.
+func (h *htmlText) walk(node *html.Node) {
+	switch node.Type {
+	case html.TextNode:
+		// The whitespace is collapsed when the line is written
+		h.line.WriteString(node.Data)
+		return
+	case html.ElementNode:
+	case html.DocumentNode:
+		h.walkChildren(node)
+		return
+	default:
+		return
+	}
+
+	switch node.DataAtom {
+	case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Svg:
+		// Not part of the visible text
+		return
+
+	case atom.Head:
+		// Only the title of the page is visible
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.DataAtom == atom.Title {
+				h.walk(child)
+			}
+		}
+
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		h.endLine()
+		level := int(node.Data[1] - '0')
+		h.line.WriteString(strings.Repeat("#", level) + " ")
+		h.walkChildren(node)
+		h.endLine()
+
+	case atom.Pre:
+		// Code blocks keep their layout
+		h.endLine()
+		h.write("```")
+		h.write(strings.TrimRight(nodeText(node), "\n"))
+		h.write("```")
+
+	case atom.Br:
+		h.endLine()
+
+	case atom.Td, atom.Th:
+		h.endLine()
+		h.walkChildren(node)
+		h.row = append(h.row, strings.Join(strings.Fields(h.line.String()), " "))
+		h.line.Reset()
+
+	case atom.Tr:
+		h.endLine()
+		h.row = nil
+		h.walkChildren(node)
+		if strings.TrimSpace(strings.Join(h.row, "")) != "" {
+			h.write("| " + strings.Join(h.row, " | ") + " |")
+		}
+		h.row = nil
+
+	case atom.Li:
+		h.endLine()
+		h.line.WriteString("- ")
+		h.walkChildren(node)
+		h.endLine()
+
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Nav, atom.Aside,
+		atom.Main, atom.Ul, atom.Ol, atom.Dl, atom.Dt, atom.Dd, atom.Blockquote, atom.Table, atom.Form,
+		atom.Figure, atom.Figcaption, atom.Hr, atom.Title, atom.Caption:
+		h.endLine()
+		h.walkChildren(node)
+		h.endLine()
+
+	default:
+		// Inline elements such as links and emphasis only contribute their text
+		h.walkChildren(node)
+	}
+}
+
+// walkChildren writes the text of the children of a node.
+func (h *htmlText) walkChildren(node *html.Node) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		h.walk(child)
+	}
+}
+
+// nodeText returns the text of a node and its children without changing its whitespace.
+func nodeText(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+
+	var text strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.DataAtom == atom.Br {
+			text.WriteString("\n")
+			continue
+		}
+		text.WriteString(nodeText(child))
+	}
+	return text.String()
+}
+
+// Markdown syntax that is removed while keeping the text it marks up
+var (
+	markdownImage      = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLink       = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	markdownReference  = regexp.MustCompile(`\[([^\]]+)\]\[[^\]]*\]`)
+	markdownDefinition = regexp.MustCompile(`^\s{0,3}\[[^\]]+\]:\s`)
+	markdownEmphasis   = regexp.MustCompile(`(\*\*|__|\*|~~)(\S(?:.*?\S)?)(\*\*|__|\*|~~)`)
+	markdownCode       = regexp.MustCompile("`([^`]+)`")
+	markdownTag        = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	markdownQuote      = regexp.MustCompile(`^\s{0,3}(>\s?)+`)
+	markdownRule       = regexp.MustCompile(`^\s{0,3}([-*_]\s*){3,}$`)
+)
+
+// appendMarkdownFileContents appends the text of a Markdown file. Headings and
+// fenced code blocks are kept as they are, while links, emphasis and inline
+// markup are reduced to their text.
+func appendMarkdownFileContents(w io.Writer, filePath string) error {
+	file, err := openTextFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	inCode := false
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Fenced code blocks are copied unchanged
+		if fence := strings.TrimSpace(line); strings.HasPrefix(fence, "```") || strings.HasPrefix(fence, "~~~") {
+			inCode = !inCode
+			line = fence
+		} else if !inCode {
+			line = stripMarkdown(line)
+		}
+
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return fmt.Errorf("failed to write Markdown text: %w", err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read Markdown file %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// stripMarkdown removes the inline markup from a line of Markdown.
+func stripMarkdown(line string) string {
+	if markdownDefinition.MatchString(line) || markdownRule.MatchString(line) {
+		return ""
+	}
+
+	line = markdownQuote.ReplaceAllString(line, "")
+	line = markdownImage.ReplaceAllString(line, "$1")
+	line = markdownLink.ReplaceAllString(line, "$1")
+	line = markdownReference.ReplaceAllString(line, "$1")
+	line = markdownCode.ReplaceAllString(line, "$1")
+	line = markdownEmphasis.ReplaceAllString(line, "$2")
+	line = markdownTag.ReplaceAllString(line, "")
+	return line
+}
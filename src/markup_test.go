@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHtmlExtractor(t *testing.T) {
+	var text strings.Builder
+	if err := appendFileContents(&text, "testdata/page.html"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Scripts, styles and the rest of the head are left out, headings keep
+	// their level, links and emphasis only keep their text
+	want := "Press section manual\n" +
+		"Home\n" +
+		"# Felt tension\n" +
+		"Keep the tension at 4.5 kN/m, see the limits table.\n" +
+		"## Limits\n" +
+		"| Parameter | Value | Unit |\n" +
+		"| Pressure | 5.0 | bar |\n" +
+		"- Check the seals weekly.\n" +
+		"- Replace the felt yearly.\n" +
+		"```\n" +
+		"tension = 4.5\n" +
+		"  pressure = 5.0\n" +
+		"```\n"
+	if text.String() != want {
+		t.Errorf("text =\n%s\nwant\n%s", text.String(), want)
+	}
+}
+
+func TestMarkdownExtractor(t *testing.T) {
+	var text strings.Builder
+	if err := appendFileContents(&text, "testdata/notes.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Headings and code blocks are kept as they are, while links, images,
+	// emphasis, quotes, rules and link definitions are reduced to their text
+	want := "# Felt tension\n" +
+		"\n" +
+		"Keep the tension at 4.5 kN/m, see the limits table.\n" +
+		"\n" +
+		"## Limits\n" +
+		"\n" +
+		"The pressure must stay below 5.0 bar.\n" +
+		"\n" +
+		"Press section\n" +
+		"\n" +
+		"```go\n" +
+		"tension := 4.5 // [not a link](x)\n" +
+		"  pressure := 5.0\n" +
+		"```\n" +
+		"\n" +
+		"\n" +
+		"\n" +
+		"Ask the vendor about spare felts.\n" +
+		"\n" +
+		"\n"
+	if text.String() != want {
+		t.Errorf("text =\n%s\nwant\n%s", text.String(), want)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// recommendedModels are the base models QueryForge was tested with. They are
+// offered in the settings even when they have not been pulled yet.
+var recommendedModels = []string{"qwen2.5:0.5b", "llama3.2:1b", "llama3.2:3b", "phi3:3.8b"}
+
+// installedModel describes a model that is available on the Ollama server.
+type installedModel struct {
+	Name          string
+	Size          int64
+	Family        string
+	ParameterSize string
+	Quantization  string
+}
+
+// listInstalledModels returns the models installed on the Ollama server, sorted by name.
+func listInstalledModels(ctx context.Context) ([]installedModel, error) {
+	client, err := newOllamaClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list models: %w", err)
+	}
+
+	models := make([]installedModel, 0, len(resp.Models))
+	for _, model := range resp.Models {
+		models = append(models, installedModel{
+			Name:          model.Name,
+			Size:          model.Size,
+			Family:        model.Details.Family,
+			ParameterSize: model.Details.ParameterSize,
+			Quantization:  model.Details.QuantizationLevel,
+		})
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Name < models[j].Name
+	})
+	return models, nil
+}
+
+// describe returns the size, quantization and family of the model.
+func (m installedModel) describe() string {
+	details := []string{formatBytes(m.Size)}
+	for _, detail := range []string{m.ParameterSize, m.Quantization, m.Family} {
+		if detail != "" {
+			details = append(details, detail)
+		}
+	}
+	return strings.Join(details, ", ")
+}
+
+// sameModel reports whether two model names refer to the same model. Ollama
+// treats a name without a tag as the "latest" tag.
+func sameModel(a, b string) bool {
+	withTag := func(name string) string {
+		if !strings.Contains(name, ":") {
+			return name + ":latest"
+		}
+		return name
+	}
+	return withTag(a) == withTag(b)
+}
+
+// isInstalled reports whether the named model is among the installed models.
+func isInstalled(name string, models []installedModel) bool {
+	for _, model := range models {
+		if sameModel(model.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// pullModel downloads a model to the Ollama server. The status and the
+// fraction completed (between 0 and 1, or -1 if unknown) are passed to
+// onProgress as the download proceeds.
+func pullModel(ctx context.Context, name string, onProgress func(status string, fraction float64)) error {
+	client, err := newOllamaClient()
+	if err != nil {
+		return err
+	}
+
+	err = client.Pull(ctx, &api.PullRequest{Model: name, Stream: &TRUE}, func(resp api.ProgressResponse) error {
+		fraction := -1.0
+		if resp.Total > 0 {
+			fraction = float64(resp.Completed) / float64(resp.Total)
+		}
+		onProgress(resp.Status, fraction)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", name, err)
+	}
+	return nil
+}
+
+// formatBytes formats a size in bytes for display, e.g. "1.3 GB".
+func formatBytes(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "kMGTPE"[exp])
+}
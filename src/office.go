@@ -0,0 +1,449 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// structuredText writes the paragraphs, headings and tables found in document
+// XML as plain text. Headings are prefixed with "#" marks and table rows are
+// written as one line with the cells separated by "|".
+type structuredText struct {
+	w          io.Writer
+	paragraph  strings.Builder
+	heading    int      // Heading level of the current paragraph, 0 for body text
+	tableDepth int      // Number of tables the current paragraph is nested in
+	cell       []string // Paragraphs of the current table cell
+	row        []string // Cells of the current table row
+	err        error    // First error returned by the writer
+}
+
+// write writes a line of output, remembering the first error.
+func (s *structuredText) write(line string) {
+	if s.err == nil {
+		_, s.err = io.WriteString(s.w, line+"\n")
+	}
+}
+
+// text adds text to the current paragraph.
+func (s *structuredText) text(text string) {
+	s.paragraph.WriteString(text)
+}
+
+// endParagraph finishes the current paragraph, adding it to the current table
+// cell or writing it out.
+func (s *structuredText) endParagraph() {
+	text := strings.TrimSpace(s.paragraph.String())
+	s.paragraph.Reset()
+	heading := s.heading
+	s.heading = 0
+
+	switch {
+	case text == "":
+		return
+	case s.tableDepth > 0:
+		s.cell = append(s.cell, text)
+	case heading > 0:
+		s.write(strings.Repeat("#", min(heading, 6)) + " " + text)
+	default:
+		s.write(text)
+	}
+}
+
+// startTable begins a table, or a table nested in the current cell.
+func (s *structuredText) startTable() {
+	s.tableDepth++
+}
+
+// endCell finishes a cell of the outermost table. Cells of nested tables are
+// kept in the cell that contains them.
+func (s *structuredText) endCell() {
+	s.endParagraph()
+	if s.tableDepth == 1 {
+		s.row = append(s.row, strings.Join(s.cell, " "))
+		s.cell = nil
+	}
+}
+
+// endRow writes a row of the outermost table.
+func (s *structuredText) endRow() {
+	if s.tableDepth != 1 {
+		return
+	}
+	if strings.TrimSpace(strings.Join(s.row, "")) != "" {
+		s.write("| " + strings.Join(s.row, " | ") + " |")
+	}
+	s.row = nil
+}
+
+// endTable finishes a table.
+func (s *structuredText) endTable() {
+	s.endParagraph()
+	if s.tableDepth > 0 {
+		s.tableDepth--
+	}
+	if s.tableDepth == 0 {
+		s.write("")
+	}
+}
+
+// appendDocxFileContents appends the text of a Word (.docx) document.
+func appendDocxFileContents(w io.Writer, filePath string) error {
+	archive, file, err := openZipFile(extractionContext(w), filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open Word document %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	// Heading styles have language dependent IDs, so their levels are read from the style names
+	headingLevels := map[string]int{}
+	if styles, err := openZipEntry(archive, "word/styles.xml"); err == nil {
+		headingLevels, err = readDocxHeadingStyles(styles)
+		styles.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read styles of %s: %w", filePath, err)
+		}
+	}
+
+	body, err := openZipEntry(archive, "word/document.xml")
+	if err != nil {
+		return fmt.Errorf("failed to read Word document %s: %w", filePath, err)
+	}
+	defer body.Close()
+
+	out := &structuredText{w: w}
+	if err := walkOfficeXML(body, out, headingLevels); err != nil {
+		return fmt.Errorf("failed to extract text from %s: %w", filePath, err)
+	}
+	return out.err
+}
+
+// readDocxHeadingStyles maps the IDs of heading styles to their heading level.
+func readDocxHeadingStyles(r io.Reader) (map[string]int, error) {
+	levels := map[string]int{}
+	decoder := xml.NewDecoder(r)
+
+	styleID := ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return levels, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch element.Name.Local {
+		case "style":
+			styleID = xmlAttr(element, "styleId")
+		case "name":
+			name := strings.ToLower(xmlAttr(element, "val"))
+			if name == "title" {
+				levels[styleID] = 1
+			} else if level, err := strconv.Atoi(strings.TrimPrefix(name, "heading ")); err == nil && strings.HasPrefix(name, "heading ") {
+				levels[styleID] = level
+			}
+		case "outlineLvl":
+			if level, err := strconv.Atoi(xmlAttr(element, "val")); err == nil && levels[styleID] == 0 && level < 9 {
+				levels[styleID] = level + 1
+			}
+		}
+	}
+}
+
+// walkOfficeXML writes the text of WordprocessingML or DrawingML markup, as
+// used by Word documents and PowerPoint slides.
+func walkOfficeXML(r io.Reader, out *structuredText, headingLevels map[string]int) error {
+	decoder := xml.NewDecoder(r)
+
+	inText := false
+	skipDepth := 0 // Depth inside fields whose text is generated, e.g. slide numbers, and tab stop definitions
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			out.endParagraph()
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if out.err != nil {
+			// Writing failed, e.g. because the extraction timed out
+			return out.err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			// Tab stop definitions are formatting, only tab elements in runs are text
+			if skipDepth > 0 || token.Name.Local == "fld" || token.Name.Local == "tabs" || token.Name.Local == "tabLst" {
+				skipDepth++
+				continue
+			}
+
+			switch token.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				out.text("\t")
+			case "br", "cr":
+				out.text(" ")
+			case "pStyle":
+				out.heading = headingLevels[xmlAttr(token, "val")]
+			case "outlineLvl":
+				if level, err := strconv.Atoi(xmlAttr(token, "val")); err == nil && level < 9 {
+					out.heading = level + 1
+				}
+			case "tbl":
+				out.endParagraph()
+				out.startTable()
+			}
+
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+
+			switch token.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				out.endParagraph()
+			case "tc":
+				out.endCell()
+			case "tr":
+				out.endRow()
+			case "tbl":
+				out.endTable()
+			}
+
+		case xml.CharData:
+			if inText && skipDepth == 0 {
+				out.text(string(token))
+			}
+		}
+	}
+}
+
+// appendPptxFileContents appends the text and speaker notes of each slide of a
+// PowerPoint (.pptx) presentation.
+func appendPptxFileContents(w io.Writer, filePath string) error {
+	archive, file, err := openZipFile(extractionContext(w), filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open PowerPoint presentation %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	slidePattern := regexp.MustCompile(`^ppt/slides/slide(\d+)\.xml$`)
+
+	// Order the slides by their number, as the archive order is arbitrary
+	type slide struct {
+		number int
+		name   string
+	}
+	var slides []slide
+	for _, file := range archive.File {
+		if match := slidePattern.FindStringSubmatch(file.Name); match != nil {
+			number, _ := strconv.Atoi(match[1])
+			slides = append(slides, slide{number: number, name: file.Name})
+		}
+	}
+	sort.Slice(slides, func(i, j int) bool {
+		return slides[i].number < slides[j].number
+	})
+
+	addPages(w, len(slides))
+	out := &structuredText{w: w}
+	for _, s := range slides {
+		out.write(fmt.Sprintf("## Slide %d", s.number))
+		if err := walkZipEntry(archive, s.name, out); err != nil {
+			return fmt.Errorf("failed to extract slide %d of %s: %w", s.number, filePath, err)
+		}
+
+		// The speaker notes are linked from the slide's relationships
+		notes, err := findZipRelationship(archive, s.name, "notesSlide")
+		if err != nil {
+			return fmt.Errorf("failed to read notes of slide %d of %s: %w", s.number, filePath, err)
+		}
+		if notes != "" {
+			out.write("Notes:")
+			if err := walkZipEntry(archive, notes, out); err != nil {
+				return fmt.Errorf("failed to extract notes of slide %d of %s: %w", s.number, filePath, err)
+			}
+		}
+		out.write("")
+	}
+
+	return out.err
+}
+
+// walkZipEntry writes the text of a DrawingML part of an archive.
+func walkZipEntry(archive *zip.Reader, name string, out *structuredText) error {
+	entry, err := openZipEntry(archive, name)
+	if err != nil {
+		return err
+	}
+	defer entry.Close()
+
+	return walkOfficeXML(entry, out, nil)
+}
+
+// findZipRelationship returns the archive path of the first part related to
+// the given part with a relationship type ending in relType, or "" if there is none.
+func findZipRelationship(archive *zip.Reader, part, relType string) (string, error) {
+	relsName := path.Join(path.Dir(part), "_rels", path.Base(part)+".rels")
+	rels, err := openZipEntry(archive, relsName)
+	if err != nil {
+		return "", nil // Parts without relationships have no rels file
+	}
+	defer rels.Close()
+
+	var relationships struct {
+		Relationship []struct {
+			Type   string `xml:"Type,attr"`
+			Target string `xml:"Target,attr"`
+		}
+	}
+	if err := xml.NewDecoder(rels).Decode(&relationships); err != nil {
+		return "", err
+	}
+
+	for _, rel := range relationships.Relationship {
+		if strings.HasSuffix(rel.Type, "/"+relType) {
+			return path.Join(path.Dir(part), rel.Target), nil
+		}
+	}
+	return "", nil
+}
+
+// maxOpenDocumentSpaces bounds the spaces written for a single <text:s>
+// element, whose count is taken from the file as it is.
+const maxOpenDocumentSpaces = 1000
+
+// appendOpenDocumentFileContents appends the text of an OpenDocument text
+// (.odt) or presentation (.odp) file, including slide notes.
+func appendOpenDocumentFileContents(w io.Writer, filePath string) error {
+	archive, file, err := openZipFile(extractionContext(w), filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open OpenDocument file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	content, err := openZipEntry(archive, "content.xml")
+	if err != nil {
+		return fmt.Errorf("failed to read OpenDocument file %s: %w", filePath, err)
+	}
+	defer content.Close()
+
+	out := &structuredText{w: w}
+	if err := walkOpenDocumentXML(content, out); err != nil {
+		return fmt.Errorf("failed to extract text from %s: %w", filePath, err)
+	}
+	return out.err
+}
+
+// walkOpenDocumentXML writes the text of OpenDocument content markup.
+func walkOpenDocumentXML(r io.Reader, out *structuredText) error {
+	decoder := xml.NewDecoder(r)
+
+	textDepth := 0 // Depth inside paragraphs and headings, where character data is text
+	slide := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			out.endParagraph()
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if out.err != nil {
+			// Writing failed, e.g. because the extraction timed out
+			return out.err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			switch token.Name.Local {
+			case "p":
+				textDepth++
+			case "h":
+				textDepth++
+				out.heading = 1
+				if level, err := strconv.Atoi(xmlAttr(token, "outline-level")); err == nil {
+					out.heading = level
+				}
+			case "s":
+				count, err := strconv.Atoi(xmlAttr(token, "c"))
+				if err != nil {
+					count = 1
+				}
+				out.text(strings.Repeat(" ", min(max(count, 1), maxOpenDocumentSpaces)))
+			case "tab":
+				out.text("\t")
+			case "line-break":
+				out.text(" ")
+			case "table":
+				out.endParagraph()
+				out.startTable()
+			case "page":
+				slide++
+				addPages(out.w, 1)
+				out.write(fmt.Sprintf("## Slide %d", slide))
+			case "notes":
+				out.write("Notes:")
+			}
+
+		case xml.EndElement:
+			switch token.Name.Local {
+			case "p", "h":
+				textDepth--
+				if textDepth == 0 {
+					out.endParagraph()
+				}
+			case "table-cell":
+				out.endCell()
+			case "table-row":
+				out.endRow()
+			case "table":
+				out.endTable()
+			case "page":
+				out.write("")
+			}
+
+		case xml.CharData:
+			if textDepth > 0 {
+				out.text(string(token))
+			}
+		}
+	}
+}
+
+// openZipEntry opens the named file in an archive.
+func openZipEntry(archive *zip.Reader, name string) (io.ReadCloser, error) {
+	for _, file := range archive.File {
+		if file.Name == name {
+			return file.Open()
+		}
+	}
+	return nil, fmt.Errorf("%s not found in archive", name)
+}
+
+// xmlAttr returns the value of the attribute with the given local name.
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
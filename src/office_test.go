@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOfficeExtractors(t *testing.T) {
+	tests := []struct {
+		file      string
+		extractor extractor
+		want      string
+	}{
+		{
+			// Tab stop definitions are not text, also in a text box following other text
+			file:      "testdata/report.docx",
+			extractor: appendDocxFileContents,
+			want: "# Felt Maintenance\n" +
+				"Tension\t4.5 kN/m\n" +
+				"Check the guide roll weekly.\n" +
+				"See box: Boxed note\n" +
+				"| Tag | Range |\n" +
+				"| PM3-FI-101 | 0-100 l/s |\n",
+		},
+		{
+			// slide10.xml follows slide2.xml, the slide number field is left out
+			file:      "testdata/deck.pptx",
+			extractor: appendPptxFileContents,
+			want: "## Slide 1\nIntroduction\nNotes:\nMention the felt guide roll.\n\n" +
+				"## Slide 2\nTension settings\n\n" +
+				"## Slide 10\nSummary\n",
+		},
+		{
+			file:      "testdata/notes.odt",
+			extractor: appendOpenDocumentFileContents,
+			want: "## Press Section\n" +
+				"Nip load\t80 kN/m\n" +
+				"Two   spaces\n" +
+				"| Roll | Cover |\n" +
+				"| Suction | Rubber |\n",
+		},
+		{
+			// The space count is taken from the file, so it is bounded
+			file:      "testdata/spaces.odt",
+			extractor: appendOpenDocumentFileContents,
+			want:      "Wide" + strings.Repeat(" ", maxOpenDocumentSpaces) + "gap\n",
+		},
+		{
+			file:      "testdata/deck.odp",
+			extractor: appendOpenDocumentFileContents,
+			want: "## Slide 1\nAgenda\n\n" +
+				"## Slide 2\nDryer section\nNotes:\nShow the steam curve.\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			var text strings.Builder
+			if err := test.extractor(&text, test.file); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(text.String()) + "\n"; got != test.want {
+				t.Errorf("text =\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPresentationsCountSlidesAsPages(t *testing.T) {
+	for file, want := range map[string]int{"testdata/deck.pptx": 3, "testdata/deck.odp": 2} {
+		var sections textSections
+		if err := appendFileContents(&sections, file); err != nil {
+			t.Fatal(err)
+		}
+		if sections.pages != want {
+			t.Errorf("%s has %d pages, want %d", file, sections.pages, want)
+		}
+	}
+}
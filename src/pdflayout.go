@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// appendPdfLayoutFileContents appends the contents of a PDF file like
+// appendPdfFileContents, but rebuilds the reading order from the position of
+// each character. Multi-column pages are read column by column, and rows of
+// tables are kept together on one line with the cells separated by "|".
+func appendPdfLayoutFileContents(w io.Writer, filePath string) error {
+	f, err := openSourceFile(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx := extractionContext(w)
+	r, err := pdf.NewReader(cancelableReaderAt{ctx: ctx, r: f}, f.Size())
+	if err != nil {
+		return fmt.Errorf("failed to open PDF file %s: %w", filePath, err)
+	}
+
+	totalPages := r.NumPage()
+	addPages(w, totalPages)
+	for i := 1; i <= totalPages; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		startSection(w, pageLocation(i))
+
+		// Blank pages and pages missing from the page tree have no text
+		page := r.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+
+		lines := pdfTextLines(page.Content().Text)
+		if _, err := io.WriteString(w, layoutPdfLines(lines)); err != nil {
+			return fmt.Errorf("failed to write PDF text: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// pdfSegment is a run of text on a line, separated from other runs on the
+// same line by a gap wider than a space, such as a table cell.
+type pdfSegment struct {
+	x0, x1 float64
+	text   string
+}
+
+// pdfLine is the text of a page at one baseline, from left to right.
+type pdfLine struct {
+	y        float64
+	size     float64 // Font size of the first character
+	segments []pdfSegment
+}
+
+// Distances relative to the font size, used to interpret the gaps between characters
+const (
+	pdfLineTolerance = 0.4 // Baselines closer than this belong to the same line
+	pdfSpaceGap      = 0.15
+	pdfSegmentGap    = 1.2 // Wider gaps separate columns or table cells
+	pdfGlyphWidth    = 0.5 // Width assumed for characters of fonts without metrics
+)
+
+// pdfTextLines groups the positioned characters of a page into lines and
+// segments, from top to bottom.
+func pdfTextLines(texts []pdf.Text) []pdfLine {
+	glyphs := make([]pdf.Text, 0, len(texts))
+	var previous pdf.Text
+	previousX := 0.0 // Position of the previous character before it was adjusted
+	for i, text := range texts {
+		x := text.X
+		if text.W <= 0 {
+			// Without font metrics the characters of a string all share one
+			// position, so they are placed after each other instead
+			text.W = pdfGlyphWidth * text.FontSize * float64(len([]rune(text.S)))
+			if i > 0 && x == previousX && text.Y == previous.Y {
+				text.X = previous.X + previous.W
+			}
+		}
+		previous, previousX = text, x
+
+		// Spaces are recognised from the gaps between the other characters
+		if strings.TrimSpace(text.S) != "" && text.FontSize > 0 {
+			glyphs = append(glyphs, text)
+		}
+	}
+
+	// PDF coordinates grow upwards, so the top of the page has the largest Y
+	sort.SliceStable(glyphs, func(i, j int) bool {
+		if glyphs[i].Y != glyphs[j].Y {
+			return glyphs[i].Y > glyphs[j].Y
+		}
+		return glyphs[i].X < glyphs[j].X
+	})
+
+	var lines []pdfLine
+	var current []pdf.Text
+	for _, glyph := range glyphs {
+		if len(current) > 0 && current[0].Y-glyph.Y > pdfLineTolerance*current[0].FontSize {
+			lines = append(lines, pdfLineOf(current))
+			current = nil
+		}
+		current = append(current, glyph)
+	}
+	if len(current) > 0 {
+		lines = append(lines, pdfLineOf(current))
+	}
+	return lines
+}
+
+// pdfLineOf builds a line from the characters found at one baseline.
+func pdfLineOf(glyphs []pdf.Text) pdfLine {
+	sort.SliceStable(glyphs, func(i, j int) bool {
+		return glyphs[i].X < glyphs[j].X
+	})
+
+	line := pdfLine{y: glyphs[0].Y, size: glyphs[0].FontSize}
+	var segment *pdfSegment
+	var text strings.Builder
+	for _, glyph := range glyphs {
+		if segment != nil {
+			gap := glyph.X - segment.x1
+			switch {
+			case gap > pdfSegmentGap*glyph.FontSize:
+				segment.text = text.String()
+				line.segments = append(line.segments, *segment)
+				segment = nil
+			case gap > pdfSpaceGap*glyph.FontSize:
+				text.WriteString(" ")
+			}
+		}
+
+		if segment == nil {
+			segment = &pdfSegment{x0: glyph.X}
+			text.Reset()
+		}
+		text.WriteString(glyph.S)
+		segment.x1 = max(segment.x1, glyph.X+glyph.W)
+	}
+	segment.text = text.String()
+	line.segments = append(line.segments, *segment)
+	return line
+}
+
+// layoutPdfLines writes the lines of a page in reading order. The page is
+// split into blocks where it leaves a larger vertical gap, e.g. between
+// two-column text and a table below it, and each block is laid out on its own.
+func layoutPdfLines(lines []pdfLine) string {
+	var text strings.Builder
+	start := 0
+	for i := 1; i <= len(lines); i++ {
+		if i == len(lines) || lines[i-1].y-lines[i].y > 2*lines[i].size {
+			text.WriteString(layoutPdfBlock(lines[start:i], 0))
+			text.WriteString("\n")
+			start = i
+		}
+	}
+	return text.String()
+}
+
+// layoutPdfBlock writes a block of lines in reading order. A block split by a
+// gutter between prose columns is written column by column, and lines with
+// several segments are written as table rows.
+func layoutPdfBlock(lines []pdfLine, depth int) string {
+	if gutter, ok := findPdfGutter(lines); ok && depth < 3 {
+		left, right := splitPdfLines(lines, gutter)
+		return layoutPdfBlock(left, depth+1) + layoutPdfBlock(right, depth+1)
+	}
+
+	var text strings.Builder
+	for _, line := range lines {
+		cells := make([]string, len(line.segments))
+		for j, segment := range line.segments {
+			cells[j] = segment.text
+		}
+		if len(cells) > 1 {
+			text.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+		} else {
+			text.WriteString(cells[0] + "\n")
+		}
+	}
+	return text.String()
+}
+
+// maxPdfPageWidth is the widest page the PDF specification allows, 200 inches
+// in points. Text placed further out on malformed pages is not looked at when
+// searching for a gutter.
+const maxPdfPageWidth = 14400
+
+// findPdfGutter looks for a vertical strip in the middle of the text that no
+// line crosses, with prose on both sides, which separates two columns. Table
+// columns are not split, as their cells are short.
+func findPdfGutter(lines []pdfLine) (float64, bool) {
+	if len(lines) < 2 {
+		return 0, false
+	}
+
+	minX, maxX := lines[0].segments[0].x0, lines[0].segments[0].x1
+	for _, line := range lines {
+		for _, segment := range line.segments {
+			minX, maxX = min(minX, segment.x0), max(maxX, segment.x1)
+		}
+	}
+
+	// Count how many segments cover each point across the page
+	span := maxX - minX
+	if !(span >= 1) || math.IsInf(span, 0) { // Also rejects NaN positions
+		return 0, false
+	}
+	width := int(min(span, maxPdfPageWidth)) + 1
+	coverage := make([]int, width)
+	for _, line := range lines {
+		for _, segment := range line.segments {
+			start := int(min(segment.x0-minX, float64(width)))
+			end := int(min(segment.x1-minX, float64(width)))
+			for x := start; x < end; x++ {
+				coverage[x]++
+			}
+		}
+	}
+
+	// Find the widest uncovered strip in the middle half of the text
+	bestStart, bestWidth := 0, 0
+	for x := width / 4; x < width*3/4; x++ {
+		if coverage[x] > 0 {
+			continue
+		}
+		start := x
+		for x < width*3/4 && coverage[x] == 0 {
+			x++
+		}
+		if x-start > bestWidth {
+			bestStart, bestWidth = start, x-start
+		}
+	}
+	if float64(bestWidth) < lines[0].size {
+		return 0, false
+	}
+	gutter := minX + float64(bestStart) + float64(bestWidth)/2
+
+	// Columns of prose have long lines on both sides, table cells are short
+	left, right := splitPdfLines(lines, gutter)
+	if !isPdfProse(left) || !isPdfProse(right) {
+		return 0, false
+	}
+	return gutter, true
+}
+
+// isPdfProse reports whether most lines hold running text rather than table cells.
+func isPdfProse(lines []pdfLine) bool {
+	prose := 0
+	for _, line := range lines {
+		words := 0
+		for _, segment := range line.segments {
+			words += len(strings.Fields(segment.text))
+		}
+		if len(line.segments) == 1 && words >= 3 {
+			prose++
+		}
+	}
+	return prose*2 > len(lines)
+}
+
+// splitPdfLines splits lines into the segments left and right of a gutter.
+func splitPdfLines(lines []pdfLine, gutter float64) (left, right []pdfLine) {
+	for _, line := range lines {
+		l, r := pdfLine{y: line.y, size: line.size}, pdfLine{y: line.y, size: line.size}
+		for _, segment := range line.segments {
+			if segment.x0 < gutter {
+				l.segments = append(l.segments, segment)
+			} else {
+				r.segments = append(r.segments, segment)
+			}
+		}
+		if len(l.segments) > 0 {
+			left = append(left, l)
+		}
+		if len(r.segments) > 0 {
+			right = append(right, r)
+		}
+	}
+	return left, right
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// extractPdfText returns the text of a PDF in plain or layout mode.
+func extractPdfText(t *testing.T, filePath string, layout bool) string {
+	t.Helper()
+	extract := findExtractor(filePath, folderOptions{PDFLayout: layout})
+	var text strings.Builder
+	if err := extract(&text, filePath); err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(text.String())
+}
+
+func TestPdfLayoutReadsColumnsInOrder(t *testing.T) {
+	// Plain mode follows the content stream, which alternates between the columns
+	plain := extractPdfText(t, "testdata/columns.pdf", false)
+	if !strings.Contains(plain, "keeps theCheck the tension") {
+		t.Errorf("plain text = %q, want the columns interleaved", plain)
+	}
+
+	layout := extractPdfText(t, "testdata/columns.pdf", true)
+	want := "The felt guide roll keeps the\n" +
+		"felt running straight through the\n" +
+		"press section of the machine.\n" +
+		"Check the tension every week\n" +
+		"and write the readings into the\n" +
+		"maintenance log of the line."
+	if layout != want {
+		t.Errorf("layout text =\n%s\nwant\n%s", layout, want)
+	}
+}
+
+func TestPdfLayoutKeepsTableRows(t *testing.T) {
+	// Plain mode runs the cells together, losing the rows
+	plain := extractPdfText(t, "testdata/table.pdf", false)
+	if plain != "ParameterValueUnitPressure5.0barSpeed1200m/min" {
+		t.Errorf("plain text = %q", plain)
+	}
+
+	layout := extractPdfText(t, "testdata/table.pdf", true)
+	want := "| Parameter | Value | Unit |\n" +
+		"| Pressure | 5.0 | bar |\n" +
+		"| Speed | 1200 | m/min |"
+	if layout != want {
+		t.Errorf("layout text =\n%s\nwant\n%s", layout, want)
+	}
+}
+
+func TestFindPdfGutterIgnoresTextFarOffThePage(t *testing.T) {
+	prose := func(y, x0, x1 float64) pdfLine {
+		return pdfLine{y: y, size: 12, segments: []pdfSegment{{x0: x0, x1: x1, text: "some words of running text here"}}}
+	}
+
+	for name, lines := range map[string][]pdfLine{
+		"huge":     {prose(700, 72, 250), prose(686, 1e15, 1e15+100)},
+		"infinite": {prose(700, 72, 250), prose(686, math.Inf(1), math.Inf(1))},
+		"NaN":      {prose(700, 72, 250), prose(686, math.NaN(), 300)},
+	} {
+		// A coverage array for the whole range would not fit into memory
+		t.Run(name, func(t *testing.T) {
+			findPdfGutter(lines)
+		})
+	}
+}
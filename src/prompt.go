@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/ollama/ollama/api"
+)
+
+// Define system content for the query
+const systemInstructions = `You are a helpful assistant by the name of PaperPal.
+Your purpose is to assist users with questions, mostly related to paper and automation.
+You were created by the Finnish company Valmet, a lead developer and supplier of process
+technologies, automation systems and services for the pulp, paper, energy industries.
+
+You should be friendly and helpful to the users. All answers should be based on the information from the documents,
+unless otherwise specified or inferred. Excerpts from the documents will appear under CONTENT in the user's
+message, followed by their QUESTION - you can refer to the excerpts directly if needed. Each excerpt starts with
+the document it was taken from and, where known, its page or location, e.g. [Manual.pdf, p. 42] - cite it when
+you use the excerpt. You should not make up any information. If you don't know the answer, you should say so.
+Do not hallucinate.
+
+If queried about a topic without the needed to refer to the documents, you should answer based on your training data.
+Make these answers as helpful as possible - and try to relate the reply back to Valmet (for paper and automation only).
+`
+
+// userPromptTemplate lays out the retrieved document content and the user's
+// question in the user message sent to the model.
+const userPromptTemplate = `{{if .Context}}CONTENT:
+{{range .Context}}[{{.Source}}{{with .Location}}, {{.}}{{end}}]
+{{.Text}}
+
+{{end}}{{end}}QUESTION:
+{{.Question}}`
+
+var userPrompt = template.Must(template.New("userPrompt").Parse(userPromptTemplate))
+
+// promptData is the data rendered into the user prompt template.
+type promptData struct {
+	Question string
+	Context  []indexedChunk
+}
+
+// renderUserPrompt renders the user message for a question and its retrieved context.
+func renderUserPrompt(question string, context []indexedChunk) (string, error) {
+	var prompt strings.Builder
+	if err := userPrompt.Execute(&prompt, promptData{Question: question, Context: context}); err != nil {
+		return "", fmt.Errorf("failed to render prompt: %w", err)
+	}
+	return prompt.String(), nil
+}
+
+// buildMessages assembles the message list for a chat request from the system
+// prompt, the earlier turns of the conversation, the retrieved document context
+// and the user's question. Only the new question carries document context.
+func buildMessages(systemPrompt string, history []api.Message, question string, context []indexedChunk) ([]api.Message, error) {
+	userMessage, err := renderUserPrompt(question, context)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]api.Message, 0, len(history)+2)
+	messages = append(messages, api.Message{Role: "system", Content: systemPrompt})
+	messages = append(messages, history...)
+	messages = append(messages, api.Message{Role: "user", Content: userMessage})
+	return messages, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestRenderUserPrompt(t *testing.T) {
+	context := []indexedChunk{
+		{Source: "Manual.pdf", Location: "p. 42", Text: "Felt tension is 4.5 kN/m."},
+		{Source: "notes.txt", Text: "Check the felt guide roll."},
+	}
+
+	prompt, err := renderUserPrompt("What is the felt tension?", context)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "CONTENT:\n" +
+		"[Manual.pdf, p. 42]\nFelt tension is 4.5 kN/m.\n\n" +
+		"[notes.txt]\nCheck the felt guide roll.\n\n" +
+		"QUESTION:\nWhat is the felt tension?"
+	if prompt != want {
+		t.Errorf("prompt =\n%s\nwant\n%s", prompt, want)
+	}
+}
+
+func TestRenderUserPromptWithoutContext(t *testing.T) {
+	prompt, err := renderUserPrompt("Hello?", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "QUESTION:\nHello?"; prompt != want {
+		t.Errorf("prompt = %q, want %q", prompt, want)
+	}
+}
+
+func TestBuildMessages(t *testing.T) {
+	history := []api.Message{
+		{Role: "user", Content: "Earlier question"},
+		{Role: "assistant", Content: "Earlier answer"},
+	}
+	context := []indexedChunk{{Source: "Manual.pdf", Location: "p. 1", Text: "Excerpt"}}
+
+	messages, err := buildMessages("System prompt", history, "New question", context)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roles := make([]string, len(messages))
+	for i, message := range messages {
+		roles[i] = message.Role
+	}
+	if got, want := strings.Join(roles, ","), "system,user,assistant,user"; got != want {
+		t.Fatalf("roles = %s, want %s", got, want)
+	}
+
+	if messages[0].Content != "System prompt" {
+		t.Errorf("system message = %q", messages[0].Content)
+	}
+	if messages[1].Content != "Earlier question" || messages[2].Content != "Earlier answer" {
+		t.Errorf("history changed: %q, %q", messages[1].Content, messages[2].Content)
+	}
+
+	// Only the new question carries the document context
+	last := messages[3].Content
+	if !strings.HasPrefix(last, "CONTENT:\n[Manual.pdf, p. 1]\nExcerpt") || !strings.HasSuffix(last, "QUESTION:\nNew question") {
+		t.Errorf("user message = %q", last)
+	}
+	for _, message := range messages[:3] {
+		if strings.Contains(message.Content, "CONTENT:") {
+			t.Errorf("context repeated in %s message", message.Role)
+		}
+	}
+}
+
+func TestSystemInstructionsAskForCitations(t *testing.T) {
+	for _, want := range []string{"CONTENT", "QUESTION", "[Manual.pdf, p. 42]"} {
+		if !strings.Contains(systemInstructions, want) {
+			t.Errorf("system instructions do not mention %s", want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileStatus tells what happened to a file while its folder was indexed.
+type fileStatus string
+
+const (
+	fileIndexed     fileStatus = "Indexed"     // Extracted and embedded
+	fileUnchanged   fileStatus = "Unchanged"   // Reused from the saved index
+	fileUnsupported fileStatus = "Unsupported" // No extractor for the file format
+	fileFailed      fileStatus = "Failed"      // The file could not be read
+	fileOverLimit   fileStatus = "Over limit"  // Left out to stay within the indexing limits
+)
+
+// fileReport records the outcome of indexing a single file.
+type fileReport struct {
+	Path     string // Path relative to the selected folder
+	Status   fileStatus
+	Reason   string // Why the file was not indexed
+	Bytes    int64
+	Pages    int           // Pages or slides, 0 for formats without pages
+	Duration time.Duration // Time spent extracting and embedding the file
+}
+
+// skipped reports whether the file is missing from the index.
+func (r fileReport) skipped() bool {
+	return r.Status == fileUnsupported || r.Status == fileFailed || r.Status == fileOverLimit
+}
+
+// skippedFileReport returns the report of a file that could not be indexed.
+func skippedFileReport(file folderFile, err error, duration time.Duration) fileReport {
+	status := fileFailed
+	if errors.Is(err, errUnsupportedFormat) {
+		status = fileUnsupported
+	}
+	return fileReport{
+		Path:     file.RelPath,
+		Status:   status,
+		Reason:   err.Error(),
+		Bytes:    file.Size,
+		Duration: duration,
+	}
+}
+
+// skippedFileRecord returns the record of a skipped file that is kept in the index.
+func skippedFileRecord(file folderFile, hash string, report fileReport) skippedFile {
+	return skippedFile{Path: file.RelPath, ModTime: file.ModTime, Size: file.Size, Hash: hash, Status: report.Status, Reason: report.Reason}
+}
+
+// recordedFileReport returns the report of a file that was skipped before and did not change since.
+func recordedFileReport(file skippedFile) fileReport {
+	return fileReport{Path: file.Path, Status: file.Status, Reason: file.Reason, Bytes: file.Size}
+}
+
+// overLimitFileReport returns the report of a file left out to stay within the indexing limits.
+func overLimitFileReport(file folderFile, err error) fileReport {
+	return fileReport{Path: file.RelPath, Status: fileOverLimit, Reason: err.Error(), Bytes: file.Size}
+}
+
+// unchangedFileReport returns the report of a file reused from the saved index.
+func unchangedFileReport(file indexedFile) fileReport {
+	return fileReport{Path: file.Path, Status: fileUnchanged, Bytes: file.Size, Pages: file.Pages}
+}
+
+// sortedFileReports returns the reports with the skipped files first, so
+// problems are seen without scrolling. Files keep their order otherwise.
+func sortedFileReports(reports []fileReport) []fileReport {
+	sorted := append([]fileReport(nil), reports...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].skipped() && !sorted[j].skipped()
+	})
+	return sorted
+}
+
+// formatFileReports returns the reports as plain text, one file per line.
+func formatFileReports(reports []fileReport) string {
+	var text strings.Builder
+	for _, report := range sortedFileReports(reports) {
+		fmt.Fprintf(&text, "%s\t%s\t%s", report.Status, report.Path, formatBytes(report.Bytes))
+		if report.Pages > 0 {
+			fmt.Fprintf(&text, "\t%d pages", report.Pages)
+		}
+		if report.Duration > 0 {
+			fmt.Fprintf(&text, "\t%s", report.Duration.Round(time.Millisecond))
+		}
+		if report.Reason != "" {
+			fmt.Fprintf(&text, "\t%s", report.Reason)
+		}
+		text.WriteString("\n")
+	}
+	return text.String()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// reportColumns are the headers and widths of the columns of the ingestion report.
+var reportColumns = []struct {
+	title string
+	width float32
+}{
+	{"File", 220},
+	{"Status", 100},
+	{"Size", 80},
+	{"Pages", 60},
+	{"Time", 70},
+	{"Reason", 360},
+}
+
+// showIngestionReport shows what happened to each file of the selected folder,
+// so users know which files the model can and can not see.
+func showIngestionReport(title string, update indexUpdate, w fyne.Window) {
+	reports := sortedFileReports(update.Files)
+
+	summary := widget.NewLabel(fmt.Sprintf("%d files found: %s", len(reports), update))
+	summary.Wrapping = fyne.TextWrapWord
+
+	table := widget.NewTable(
+		func() (int, int) {
+			return len(reports), len(reportColumns)
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("")
+			label.Truncation = fyne.TextTruncateEllipsis
+			return label
+		},
+		func(id widget.TableCellID, cell fyne.CanvasObject) {
+			cell.(*widget.Label).SetText(reportCell(reports[id.Row], id.Col))
+		},
+	)
+	table.ShowHeaderRow = true
+	table.CreateHeader = func() fyne.CanvasObject {
+		return widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	}
+	table.UpdateHeader = func(id widget.TableCellID, cell fyne.CanvasObject) {
+		if id.Row < 0 && id.Col >= 0 {
+			cell.(*widget.Label).SetText(reportColumns[id.Col].title)
+		}
+	}
+	for col, column := range reportColumns {
+		table.SetColumnWidth(col, column.width)
+	}
+
+	// The report can be copied, e.g. to pass the list of unreadable files on
+	copyButton := widget.NewButtonWithIcon("Copy Report", theme.ContentCopyIcon(), func() {
+		w.Clipboard().SetContent(formatFileReports(update.Files))
+	})
+
+	content := container.NewBorder(summary, container.NewHBox(copyButton), nil, nil, table)
+	report := dialog.NewCustom(title, "Close", content, w)
+	report.Resize(fyne.NewSize(900, 500))
+	report.Show()
+}
+
+// reportCell returns the text shown in a column of the ingestion report.
+func reportCell(report fileReport, col int) string {
+	switch col {
+	case 0:
+		return report.Path
+	case 1:
+		return string(report.Status)
+	case 2:
+		return formatBytes(report.Bytes)
+	case 3:
+		if report.Pages > 0 {
+			return strconv.Itoa(report.Pages)
+		}
+	case 4:
+		if report.Duration > 0 {
+			return report.Duration.Round(time.Millisecond).String()
+		}
+	case 5:
+		return report.Reason
+	}
+	return ""
+}
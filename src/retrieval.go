@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Chunking and retrieval parameters. Chunks are kept well below the context
+// window of the small embedding models, and only the best matches are sent to
+// the chat model with each question.
+const (
+	chunkSize    = 800 // Maximum characters per chunk
+	chunkOverlap = 160 // Characters shared between neighbouring chunks
+	topK         = 4   // Number of chunks sent with each question
+)
+
+// indexedChunk is a piece of document text together with its embedding.
+type indexedChunk struct {
+	Source    string // Path of the file the chunk was taken from
+	Location  string // Where in the file the chunk was taken from, if known
+	Text      string
+	Embedding []float32
+}
+
+// indexedFile holds the embedded chunks of a single file and the metadata of
+// the file they were extracted from.
+type indexedFile struct {
+	Path       string
+	ModTime    time.Time
+	Size       int64
+	Hash       string // SHA-256 of the file contents
+	Pages      int    // Pages or slides, 0 for formats without pages
+	Characters int    // Characters of text extracted from the file
+	Chunks     []indexedChunk
+}
+
+// skippedFile is a file that could not be indexed. It is kept in the index so
+// the file is not read again until its contents change, or for files over the
+// character limit, until other files make room for it.
+type skippedFile struct {
+	Path    string
+	ModTime time.Time
+	Size    int64
+	Hash    string // SHA-256 of the file contents, "" if the file could not be read
+	Status  fileStatus
+	Reason  string // Why the file was not indexed
+
+	Characters int           // Text extracted from a file over the character limit
+	Timeout    time.Duration // File timeout the extraction ran out of, 0 if it did not time out
+}
+
+// unchanged reports whether the file is the same as when it was skipped.
+func (s skippedFile) unchanged(file folderFile) bool {
+	return s.ModTime.Equal(file.ModTime) && s.Size == file.Size
+}
+
+// timedOutWithin reports whether the file timed out with a timeout at least as
+// long as the given one, so reading it again would time out as well.
+func (s skippedFile) timedOutWithin(timeout time.Duration) bool {
+	return s.Timeout > 0 && timeout > 0 && timeout <= s.Timeout
+}
+
+// indexVersion is increased whenever the extractors change in a way that
+// requires saved indexes to be rebuilt, e.g. when PDFs were split into pages
+// or text files were decoded from their own encoding.
+const indexVersion = 3
+
+// vectorIndex holds the embedded chunks of the selected folder.
+type vectorIndex struct {
+	Version        int
+	Folder         string
+	EmbeddingModel string
+	VisionModel    string // Model that described the images, "" if images were not indexed
+	Options        folderOptions
+	Files          []indexedFile
+	Skipped        []skippedFile // Files that could not be indexed, until they change
+}
+
+var (
+	documentIndex      *vectorIndex // Global variable to store the index of the selected folder
+	documentIndexMutex sync.RWMutex
+)
+
+// getDocumentIndex returns the index of the selected folder, or nil if no folder is selected.
+// The returned index is never modified; updates replace it with a new one.
+func getDocumentIndex() *vectorIndex {
+	documentIndexMutex.RLock()
+	defer documentIndexMutex.RUnlock()
+	return documentIndex
+}
+
+// setDocumentIndex sets the index used for answering questions.
+func setDocumentIndex(index *vectorIndex) {
+	documentIndexMutex.Lock()
+	defer documentIndexMutex.Unlock()
+	documentIndex = index
+}
+
+// replaceDocumentIndex sets the index used for answering questions, unless
+// the current index is no longer old.
+func replaceDocumentIndex(old, index *vectorIndex) bool {
+	documentIndexMutex.Lock()
+	defer documentIndexMutex.Unlock()
+	if documentIndex != old {
+		return false
+	}
+	documentIndex = index
+	return true
+}
+
+// chunkCount returns the number of chunks in the index.
+func (index *vectorIndex) chunkCount() int {
+	count := 0
+	for _, file := range index.Files {
+		count += len(file.Chunks)
+	}
+	return count
+}
+
+// search embeds the question and returns the k chunks most similar to it.
+func (index *vectorIndex) search(ctx context.Context, question string, k int) ([]indexedChunk, error) {
+	embeddings, err := embedTexts(ctx, []string{question})
+	if err != nil {
+		return nil, err
+	}
+	query := embeddings[0]
+
+	type scoredChunk struct {
+		chunk indexedChunk
+		score float64
+	}
+
+	scored := make([]scoredChunk, 0, index.chunkCount())
+	for _, file := range index.Files {
+		for _, chunk := range file.Chunks {
+			scored = append(scored, scoredChunk{chunk: chunk, score: cosineSimilarity(query, chunk.Embedding)})
+		}
+	}
+
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].score > scored[j].score
+	})
+
+	results := make([]indexedChunk, 0, k)
+	for i := 0; i < len(scored) && i < k; i++ {
+		results = append(results, scored[i].chunk)
+	}
+	return results, nil
+}
+
+// chunkText splits text into chunks of at most size characters on word
+// boundaries, repeating roughly overlap characters between neighbouring chunks.
+func chunkText(text string, size, overlap int) []string {
+	words := strings.Fields(text)
+
+	var chunks []string
+	start := 0
+	for start < len(words) {
+		// Take words until the chunk is full
+		length := 0
+		end := start
+		for end < len(words) && (end == start || length+1+len(words[end]) <= size) {
+			length += len(words[end]) + 1
+			end++
+		}
+		chunks = append(chunks, strings.Join(words[start:end], " "))
+
+		if end == len(words) {
+			break
+		}
+
+		// Step back to share the tail of this chunk with the next one
+		next := end
+		for shared := 0; next > start+1 && shared+len(words[next-1]) < overlap; next-- {
+			shared += len(words[next-1]) + 1
+		}
+		start = next
+	}
+
+	return chunks
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// rtfSkippedDestinations are RTF groups that hold no document text, such as
+// font tables, embedded pictures and field instructions. Headers and footers
+// are left out as well, as they repeat on every page.
+var rtfSkippedDestinations = map[string]bool{
+	"fonttbl": true, "colortbl": true, "stylesheet": true, "info": true, "listtable": true,
+	"listoverridetable": true, "rsidtbl": true, "generator": true, "xmlnstbl": true,
+	"latentstyles": true, "datastore": true, "themedata": true, "colorschememapping": true,
+	"pict": true, "object": true, "shpinst": true, "nonshppict": true, "fldinst": true,
+	"bkmkstart": true, "bkmkend": true, "filetbl": true, "revtbl": true, "mmathPr": true,
+	"header": true, "headerl": true, "headerr": true, "headerf": true,
+	"footer": true, "footerl": true, "footerr": true, "footerf": true,
+	"xe": true, "tc": true,
+}
+
+// rtfSymbols are control words that stand for a single character.
+var rtfSymbols = map[string]string{
+	"tab": "\t", "emdash": "—", "endash": "–", "bullet": "•", "emspace": " ", "enspace": " ",
+	"lquote": "‘", "rquote": "’", "ldblquote": "“", "rdblquote": "”",
+}
+
+// rtfCodePages are the character sets selected with \ansicpg that bytes
+// written as \'hh are decoded with. Other code pages are read as Windows-1252.
+var rtfCodePages = map[int]*charmap.Charmap{
+	437: charmap.CodePage437, 850: charmap.CodePage850, 1250: charmap.Windows1250,
+	1251: charmap.Windows1251, 1252: charmap.Windows1252, 1253: charmap.Windows1253,
+	1254: charmap.Windows1254, 1255: charmap.Windows1255, 1256: charmap.Windows1256,
+	1257: charmap.Windows1257, 1258: charmap.Windows1258, 10000: charmap.Macintosh,
+}
+
+// appendRtfFileContents appends the text of an RTF document, dropping the
+// control words and the groups that hold formatting rather than text. Table
+// rows are written with their cells separated by "|".
+func appendRtfFileContents(w io.Writer, filePath string) error {
+	file, err := openSourceFile(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("failed to read RTF file %s: %w", filePath, err)
+	}
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte(`{\rtf`)) {
+		return fmt.Errorf("%s is not an RTF document", filePath)
+	}
+
+	if _, err := io.WriteString(w, rtfText(data)); err != nil {
+		return fmt.Errorf("failed to write RTF text: %w", err)
+	}
+	return nil
+}
+
+// rtfGroup is the state of an RTF group, which is restored when it ends.
+type rtfGroup struct {
+	skip         bool // The group holds no document text
+	unicodeSkip  int  // Fallback characters following each \u character
+	destinations bool // The group has just started, so a destination may follow
+}
+
+// rtfText converts an RTF document to plain text.
+func rtfText(data []byte) string {
+	var text, line strings.Builder
+	var cells []string
+	codePage := charmap.Windows1252
+
+	endLine := func() {
+		text.WriteString(strings.TrimRight(line.String(), " ") + "\n")
+		line.Reset()
+	}
+
+	state := rtfGroup{unicodeSkip: 1}
+	var stack []rtfGroup
+	skipChars := 0 // Fallback characters still to skip after a \u character
+
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch c {
+		case '{':
+			stack = append(stack, state)
+			state.destinations = true
+			i++
+			continue
+		case '}':
+			if len(stack) > 0 {
+				state = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+			skipChars = 0
+			i++
+			continue
+		case '\r', '\n':
+			// Line breaks in the file are not part of the text
+			i++
+			continue
+		case '\\':
+		default:
+			if skipChars > 0 {
+				skipChars--
+			} else if !state.skip && c >= 0x80 {
+				// Text outside ASCII should be escaped, but bytes written as they are use the code page as well
+				line.WriteRune(codePage.DecodeByte(c))
+			} else if !state.skip {
+				line.WriteByte(c)
+			}
+			state.destinations = false
+			i++
+			continue
+		}
+
+		word, param, hasParam, next := readRtfControl(data, i)
+		i = next
+		destination := state.destinations
+		state.destinations = false
+
+		// Groups starting with \* may be skipped by readers that do not know them
+		if word == "*" && destination {
+			state.skip = true
+			continue
+		}
+		if destination && rtfSkippedDestinations[word] {
+			state.skip = true
+			continue
+		}
+		if word == "bin" && hasParam {
+			// Binary data of the given length follows
+			i = min(i+max(param, 0), len(data))
+			continue
+		}
+		if state.skip {
+			continue
+		}
+
+		// Control symbols standing for characters replace a fallback character
+		if skipChars > 0 && (word == "'" || rtfSymbols[word] != "") {
+			skipChars--
+			continue
+		}
+
+		switch word {
+		case "par", "line", "sect", "page":
+			endLine()
+		case "cell":
+			cells = append(cells, strings.Join(strings.Fields(line.String()), " "))
+			line.Reset()
+		case "row":
+			text.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+			cells = nil
+			line.Reset()
+		case "ansicpg":
+			if cm, ok := rtfCodePages[param]; ok {
+				codePage = cm
+			}
+		case "uc":
+			state.unicodeSkip = max(param, 0)
+		case "u":
+			// Characters above 32767 are written as negative numbers
+			if param < 0 {
+				param += 65536
+			}
+			line.WriteRune(rune(param))
+			skipChars = state.unicodeSkip
+		case "'":
+			line.WriteRune(codePage.DecodeByte(byte(param)))
+		case "\\", "{", "}":
+			line.WriteString(word)
+		case "~":
+			line.WriteString(" ")
+		case "_":
+			line.WriteString("-")
+		default:
+			line.WriteString(rtfSymbols[word])
+		}
+	}
+
+	if strings.TrimSpace(line.String()) != "" {
+		endLine()
+	}
+	return text.String()
+}
+
+// readRtfControl reads the control word or symbol starting with the backslash
+// at data[i]. It returns the word without the backslash, its numeric parameter
+// and the position following it. For \'hh the parameter is the byte value.
+func readRtfControl(data []byte, i int) (word string, param int, hasParam bool, next int) {
+	i++ // Backslash
+	if i >= len(data) {
+		return "", 0, false, i
+	}
+
+	// Control symbols are a single character that is not a letter
+	if c := data[i]; !isRtfLetter(c) {
+		if c == '\'' && i+2 < len(data) {
+			value, err := strconv.ParseUint(string(data[i+1:i+3]), 16, 8)
+			if err == nil {
+				return "'", int(value), true, i + 3
+			}
+		}
+		return string(c), 0, false, i + 1
+	}
+
+	start := i
+	for i < len(data) && isRtfLetter(data[i]) {
+		i++
+	}
+	word = string(data[start:i])
+
+	paramStart := i
+	if i < len(data) && data[i] == '-' {
+		i++
+	}
+	for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+		i++
+	}
+	if i > paramStart {
+		if value, err := strconv.Atoi(string(data[paramStart:i])); err == nil {
+			param, hasParam = value, true
+		}
+	}
+
+	// A single space delimits the control word and is not part of the text
+	if i < len(data) && data[i] == ' ' {
+		i++
+	}
+	return word, param, hasParam, i
+}
+
+// isRtfLetter reports whether c may be part of the name of a control word.
+func isRtfLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
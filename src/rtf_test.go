@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRtfExtractor(t *testing.T) {
+	var text strings.Builder
+	if err := appendFileContents(&text, "testdata/maintenance.rtf"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unicode characters replace their fallback, \'hh bytes are decoded with
+	// \ansicpg and the font table and \* groups are left out
+	want := "Felt tension — maintenance\n" +
+		"Felt Тест\n" +
+		"Пресс № 4\n" +
+		"| Parameter | Value | Unit |\n" +
+		"| Pressure | 5.0 | bar |\n" +
+		"Check the seals weekly.\n"
+	if text.String() != want {
+		t.Errorf("text =\n%s\nwant\n%s", text.String(), want)
+	}
+}
+
+func TestRtfTextDecodesRawBytesWithTheCodePage(t *testing.T) {
+	for name, test := range map[string]struct {
+		rtf  string
+		want string
+	}{
+		"windows-1252": {rtf: "{\\rtf1\\ansi Gr\xfc\xdfe\\par}", want: "Grüße\n"},
+		"windows-1251": {rtf: "{\\rtf1\\ansi\\ansicpg1251 \xcf\xf0\xe5\xf1\xf1\\par}", want: "Пресс\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			text := rtfText([]byte(test.rtf))
+			if !utf8.ValidString(text) || text != test.want {
+				t.Errorf("text = %q, want %q", text, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// newModelSettings builds the settings section for choosing the base model.
+// The models installed on the Ollama server are listed with their details, and
+// models that are not installed yet can be pulled from here.
+func newModelSettings() fyne.CanvasObject {
+	// Maps the labels shown in the dropdown to model names, filled in the background
+	var (
+		mu         sync.Mutex
+		modelNames = map[string]string{}
+		installed  = map[string]bool{}
+		listed     bool // Set once the installed models are known
+		// pickedModel is a model chosen in the dropdown that is not installed yet
+		pickedModel string
+		// reverting is set while the dropdown is changed by the code, not the user
+		reverting bool
+	)
+
+	status := widget.NewLabel("Loading installed models...")
+	status.Wrapping = fyne.TextWrapWord
+
+	pullEntry := widget.NewEntry()
+	pullEntry.SetPlaceHolder("Model to pull, e.g. llama3.2:1b")
+	pullProgress := widget.NewProgressBar()
+	pullProgress.Hide()
+
+	// modelLabel returns the dropdown label of a model, or "" if it is not listed
+	modelLabel := func(name string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		for label, labelName := range modelNames {
+			if sameModel(labelName, name) {
+				return label
+			}
+		}
+		return ""
+	}
+
+	var selectModel *widget.Select
+
+	// selectQuietly shows a model in the dropdown without handling it as picked
+	// by the user, which would replace the model to pull and the status
+	selectQuietly := func(label string) {
+		mu.Lock()
+		reverting = true
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			reverting = false
+			mu.Unlock()
+		}()
+		selectModel.SetSelected(label)
+	}
+
+	// Select the model from the dropdown
+	selectModel = widget.NewSelect(nil, func(label string) {
+		mu.Lock()
+		if reverting {
+			mu.Unlock()
+			return
+		}
+		name, ok := modelNames[label]
+		missing := listed && !installed[name]
+		mu.Unlock()
+
+		if !ok || (!missing && sameModel(name, getOllamaModelName())) {
+			return
+		}
+		if missing {
+			// Offer to pull the model instead of failing at query time. The
+			// selection goes back to the model in use until the pull succeeds.
+			status.SetText(fmt.Sprintf("%s is not installed - pull it below to use it.", name))
+			pullEntry.SetText(name)
+			if current := getOllamaModelName(); !sameModel(name, current) {
+				mu.Lock()
+				pickedModel = name
+				mu.Unlock()
+				selectQuietly(modelLabel(current))
+			}
+			return
+		}
+
+		fmt.Println("Selected model:", name)
+		setOllamaModelName(name)
+		status.SetText(fmt.Sprintf("Using %s.", name))
+	})
+	selectModel.PlaceHolder = "Loading..."
+
+	// loadModels fills the dropdown with the installed models, followed by the
+	// current and recommended models if they are missing
+	loadModels := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		models, err := listInstalledModels(ctx)
+		current := getOllamaModelName()
+		names := map[string]string{}
+		available := map[string]bool{}
+
+		var options []string
+		currentLabel := ""
+		for _, model := range models {
+			label := fmt.Sprintf("%s (%s)", model.Name, model.describe())
+			options = append(options, label)
+			names[label] = model.Name
+			available[model.Name] = true
+			if sameModel(model.Name, current) {
+				currentLabel = label
+			}
+		}
+
+		for _, name := range append([]string{current}, recommendedModels...) {
+			label := name
+			if err == nil {
+				label = name + " (not installed)"
+			}
+			if isInstalled(name, models) || names[label] != "" {
+				continue
+			}
+			options = append(options, label)
+			names[label] = name
+			if name == current {
+				currentLabel = label
+			}
+		}
+
+		mu.Lock()
+		modelNames, installed, listed = names, available, err == nil
+		mu.Unlock()
+
+		if err != nil {
+			status.SetText(fmt.Sprintf("Could not list installed models: %v", err))
+		} else {
+			status.SetText(fmt.Sprintf("%d models installed, using %s.", len(models), current))
+		}
+
+		selectModel.Options = options
+		selectModel.Refresh()
+		selectModel.SetSelected(currentLabel)
+	}
+	go loadModels()
+
+	// Pull button to download a model, showing the streamed progress
+	var pullButton *widget.Button
+	pullButton = widget.NewButtonWithIcon("Pull", theme.DownloadIcon(), func() {
+		name := strings.TrimSpace(pullEntry.Text)
+		if name == "" {
+			return
+		}
+
+		pullButton.Disable()
+		pullProgress.SetValue(0)
+		pullProgress.Show()
+
+		go func() {
+			defer func() {
+				pullButton.Enable()
+				pullProgress.Hide()
+			}()
+
+			err := pullModel(context.Background(), name, func(progress string, fraction float64) {
+				status.SetText(fmt.Sprintf("Pulling %s: %s", name, progress))
+				if fraction >= 0 {
+					pullProgress.SetValue(fraction)
+				}
+			})
+			if err != nil {
+				status.SetText(err.Error())
+				return
+			}
+
+			loadModels()
+
+			// A model that was picked before it was installed is used once it is pulled
+			mu.Lock()
+			picked := name == pickedModel
+			mu.Unlock()
+			if picked {
+				setOllamaModelName(name)
+				selectQuietly(modelLabel(name))
+				status.SetText(fmt.Sprintf("Pulled %s - using it now.", name))
+				return
+			}
+			status.SetText(fmt.Sprintf("Pulled %s - select it above to use it.", name))
+		}()
+	})
+
+	return container.NewVBox(
+		selectModel,
+		container.NewBorder(nil, nil, nil, pullButton, pullEntry),
+		pullProgress,
+		status,
+	)
+}
+
+// Preference keys for the indexing settings
+const (
+	plainTextExtensionsPreference = "plainTextExtensions"
+	maxFilesPreference            = "maxFiles"
+	maxFileSizePreference         = "maxFileSizeMB"
+	maxCharactersPreference       = "maxCharacters"
+	maxDepthPreference            = "maxFolderDepth"
+	workersPreference             = "extractionWorkers"
+	fileTimeoutPreference         = "fileTimeoutSeconds"
+	visionModelPreference         = "visionModel"
+)
+
+// loadIndexingSettings applies the saved indexing settings.
+func loadIndexingSettings(prefs fyne.Preferences) {
+	extensions := prefs.StringWithFallback(plainTextExtensionsPreference, strings.Join(defaultPlainTextExtensions, ", "))
+	setPlainTextExtensions(parseExtensions(extensions))
+
+	limits := defaultIndexLimits()
+	limits.MaxFiles = prefs.IntWithFallback(maxFilesPreference, limits.MaxFiles)
+	limits.MaxFileSize = int64(prefs.IntWithFallback(maxFileSizePreference, int(limits.MaxFileSize/1000/1000))) * 1000 * 1000
+	limits.MaxCharacters = prefs.IntWithFallback(maxCharactersPreference, limits.MaxCharacters)
+	limits.MaxDepth = prefs.IntWithFallback(maxDepthPreference, limits.MaxDepth)
+	limits.Workers = prefs.IntWithFallback(workersPreference, limits.Workers)
+	if seconds := prefs.Int(fileTimeoutPreference); seconds > 0 {
+		limits.FileTimeout = time.Duration(seconds) * time.Second
+	}
+	setIndexLimits(limits)
+
+	setVisionModelName(prefs.String(visionModelPreference))
+}
+
+// validateLimit checks that a limit entry holds a whole number that is not negative.
+func validateLimit(text string) error {
+	if value, err := strconv.Atoi(strings.TrimSpace(text)); err != nil || value < 0 {
+		return fmt.Errorf("enter a whole number, or 0 for no limit")
+	}
+	return nil
+}
+
+// newIndexingSettings builds the settings section for how folders are indexed.
+// The settings apply the next time a folder is indexed.
+func newIndexingSettings(prefs fyne.Preferences) fyne.CanvasObject {
+	// Extensions of text-like files that are indexed as plain text
+	extensionsEntry := widget.NewMultiLineEntry()
+	extensionsEntry.Wrapping = fyne.TextWrapWord
+	extensionsEntry.SetMinRowsVisible(2)
+	extensionsEntry.SetText(prefs.StringWithFallback(plainTextExtensionsPreference, strings.Join(defaultPlainTextExtensions, ", ")))
+
+	// Limits on how much of a folder is indexed, 0 for no limit
+	limits := getIndexLimits()
+	limitEntry := func(value int) *widget.Entry {
+		entry := widget.NewEntry()
+		entry.SetText(strconv.Itoa(value))
+		entry.Validator = validateLimit
+		return entry
+	}
+	maxFilesEntry := limitEntry(limits.MaxFiles)
+	maxFileSizeEntry := limitEntry(int(limits.MaxFileSize / 1000 / 1000))
+	maxCharactersEntry := limitEntry(limits.MaxCharacters)
+	maxDepthEntry := limitEntry(limits.MaxDepth)
+	workersEntry := limitEntry(limits.Workers)
+	fileTimeoutEntry := limitEntry(int(limits.FileTimeout / time.Second))
+	fileTimeoutEntry.Validator = func(text string) error {
+		// Every file gets a deadline, as some malformed PDFs make the parser loop
+		if seconds, err := strconv.Atoi(strings.TrimSpace(text)); err != nil || seconds <= 0 {
+			return fmt.Errorf("timeout must be a positive number of seconds")
+		}
+		return nil
+	}
+
+	// Model describing images, images are skipped without one
+	visionModelEntry := widget.NewEntry()
+	visionModelEntry.SetPlaceHolder("e.g. llava")
+	visionModelEntry.SetText(getVisionModelName())
+
+	status := widget.NewLabel("")
+	status.Wrapping = fyne.TextWrapWord
+
+	form := &widget.Form{
+		Items: []*widget.FormItem{
+			widget.NewFormItem("Plain text files", extensionsEntry),
+			widget.NewFormItem("Max files", maxFilesEntry),
+			widget.NewFormItem("Max file size (MB)", maxFileSizeEntry),
+			widget.NewFormItem("Max characters", maxCharactersEntry),
+			widget.NewFormItem("Max folder depth", maxDepthEntry),
+			widget.NewFormItem("Parallel files", workersEntry),
+			widget.NewFormItem("File timeout (s)", fileTimeoutEntry),
+			widget.NewFormItem("Vision model", visionModelEntry),
+		},
+		SubmitText: "Save",
+	}
+	form.Items[0].HintText = "Extensions indexed as plain text, separated by commas"
+	form.Items[3].HintText = "Total text extracted from the folder, 0 for no limit"
+	form.Items[5].HintText = "Files extracted at the same time, 0 for one per CPU"
+	form.Items[7].HintText = "Ollama model describing .png and .jpg images, empty to skip images"
+
+	form.OnSubmit = func() {
+		extensions := parseExtensions(extensionsEntry.Text)
+		extensionsChanged := strings.Join(extensions, ", ") != prefs.StringWithFallback(plainTextExtensionsPreference, strings.Join(defaultPlainTextExtensions, ", "))
+		prefs.SetString(plainTextExtensionsPreference, strings.Join(extensions, ", "))
+		visionModelChanged := strings.TrimSpace(visionModelEntry.Text) != getVisionModelName()
+		prefs.SetString(visionModelPreference, strings.TrimSpace(visionModelEntry.Text))
+
+		// The entries were validated by the form, so parsing can not fail here
+		for key, entry := range map[string]*widget.Entry{
+			maxFilesPreference:      maxFilesEntry,
+			maxFileSizePreference:   maxFileSizeEntry,
+			maxCharactersPreference: maxCharactersEntry,
+			maxDepthPreference:      maxDepthEntry,
+			workersPreference:       workersEntry,
+			fileTimeoutPreference:   fileTimeoutEntry,
+		} {
+			value, _ := strconv.Atoi(strings.TrimSpace(entry.Text))
+			prefs.SetInt(key, value)
+		}
+
+		loadIndexingSettings(prefs)
+		status.SetText("Saved. Select the folder again to index it with the new settings.")
+
+		// Files that are no longer plain text are removed from the index, and new ones
+		// added. Images are described again by the new vision model.
+		if index := getDocumentIndex(); (extensionsChanged || visionModelChanged) && index != nil {
+			status.SetText("Saved. Re-indexing the folder with the new settings...")
+			go func() {
+				updated, update, err := refreshDocumentIndex(context.Background(), index.Folder)
+				if err != nil {
+					status.SetText(fmt.Sprintf("Saved, but re-indexing failed: %v", err))
+					return
+				}
+				if updated != nil {
+					status.SetText(fmt.Sprintf("Saved. Re-indexed %s: %s", filepath.Base(updated.Folder), update))
+				}
+			}()
+		}
+	}
+
+	return container.NewVBox(form, status)
+}
+
+// Preference keys for the folder options last used when selecting a folder
+const (
+	includePatternsPreference = "includePatterns"
+	excludePatternsPreference = "excludePatterns"
+	pdfLayoutPreference       = "pdfLayout"
+)
+
+// showFolderOptions asks which files of a newly selected folder to index,
+// starting from the options the folder was indexed with before, or the
+// patterns used last. onConfirm is called with the chosen options.
+func showFolderOptions(folder string, prefs fyne.Preferences, w fyne.Window, onConfirm func(options folderOptions)) {
+	options := folderOptions{
+		Include:   parsePatternList(prefs.String(includePatternsPreference)),
+		Exclude:   parsePatternList(prefs.String(excludePatternsPreference)),
+		PDFLayout: prefs.Bool(pdfLayoutPreference),
+	}
+	if index := getDocumentIndex(); index != nil && index.Folder == folder {
+		options = index.Options
+	}
+
+	validatePatterns := func(text string) error {
+		_, err := parseIgnoreRules(parsePatternList(text))
+		return err
+	}
+
+	includeEntry := widget.NewEntry()
+	includeEntry.SetText(strings.Join(options.Include, ", "))
+	includeEntry.SetPlaceHolder("All files, or e.g. *.pdf, manuals/**")
+	includeEntry.Validator = validatePatterns
+
+	excludeEntry := widget.NewEntry()
+	excludeEntry.SetText(strings.Join(options.Exclude, ", "))
+	excludeEntry.SetPlaceHolder("e.g. archive/, *draft*")
+	excludeEntry.Validator = validatePatterns
+
+	// Layout mode for manuals with several columns or tables of parameters
+	layoutCheck := widget.NewCheck("Preserve columns and tables", nil)
+	layoutCheck.SetChecked(options.PDFLayout)
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Include", includeEntry),
+		widget.NewFormItem("Exclude", excludeEntry),
+		widget.NewFormItem("PDF layout", layoutCheck),
+	}
+	items[0].HintText = "Glob patterns separated by commas"
+	items[1].HintText = "Also honors " + ignoreFileName + " in the folder"
+	items[2].HintText = "Slower, but keeps the reading order of multi-column pages and table rows"
+
+	dialog.ShowForm("Index "+filepath.Base(folder), "Index", "Cancel", items, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+
+		options := folderOptions{
+			Include:   parsePatternList(includeEntry.Text),
+			Exclude:   parsePatternList(excludeEntry.Text),
+			PDFLayout: layoutCheck.Checked,
+		}
+		prefs.SetString(includePatternsPreference, strings.Join(options.Include, ", "))
+		prefs.SetString(excludePatternsPreference, strings.Join(options.Exclude, ", "))
+		prefs.SetBool(pdfLayoutPreference, options.PDFLayout)
+		onConfirm(options)
+	}, w)
+}
+
+// Preference keys for the Ollama connection settings
+const (
+	endpointPreference    = "ollamaEndpoint"
+	timeoutPreference     = "ollamaTimeoutSeconds"
+	bearerTokenPreference = "ollamaBearerToken"
+	headersPreference     = "ollamaHeaders"
+)
+
+// loadOllamaConnection reads the saved connection settings, falling back to
+// the defaults for anything that was never saved or can not be parsed.
+func loadOllamaConnection(prefs fyne.Preferences) ollamaConnection {
+	connection := defaultOllamaConnection()
+
+	connection.Endpoint = prefs.StringWithFallback(endpointPreference, connection.Endpoint)
+	if seconds := prefs.Int(timeoutPreference); seconds > 0 {
+		connection.Timeout = time.Duration(seconds) * time.Second
+	}
+	connection.BearerToken = prefs.String(bearerTokenPreference)
+
+	headers, err := parseHeaders(prefs.String(headersPreference))
+	if err != nil {
+		fmt.Println("Ignoring saved headers:", err)
+	} else {
+		connection.Headers = headers
+	}
+
+	return connection
+}
+
+// saveOllamaConnection stores the connection settings in the preferences.
+func saveOllamaConnection(prefs fyne.Preferences, connection ollamaConnection) {
+	prefs.SetString(endpointPreference, connection.Endpoint)
+	prefs.SetInt(timeoutPreference, int(connection.Timeout/time.Second))
+	prefs.SetString(bearerTokenPreference, connection.BearerToken)
+	prefs.SetString(headersPreference, formatHeaders(connection.Headers))
+}
+
+// newConnectionSettings builds the settings section for the Ollama connection.
+// Saving checks that the server answers before the settings are stored and used.
+func newConnectionSettings(prefs fyne.Preferences) fyne.CanvasObject {
+	current := getOllamaConnection()
+
+	// Endpoint of the Ollama server, or of a reverse proxy in front of it
+	endpointEntry := widget.NewEntry()
+	endpointEntry.SetText(current.Endpoint)
+	endpointEntry.SetPlaceHolder(defaultOllamaEndpoint)
+	endpointEntry.Validator = func(text string) error {
+		_, err := parseOllamaEndpoint(text)
+		return err
+	}
+
+	// Seconds to wait for the server to start answering
+	timeoutEntry := widget.NewEntry()
+	timeoutEntry.SetText(strconv.Itoa(int(current.Timeout / time.Second)))
+	timeoutEntry.Validator = func(text string) error {
+		seconds, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil || seconds <= 0 {
+			return fmt.Errorf("timeout must be a positive number of seconds")
+		}
+		return nil
+	}
+
+	// Optional authentication for a reverse proxy
+	tokenEntry := widget.NewPasswordEntry()
+	tokenEntry.SetText(current.BearerToken)
+	tokenEntry.SetPlaceHolder("Optional")
+
+	headersEntry := widget.NewMultiLineEntry()
+	headersEntry.SetText(formatHeaders(current.Headers))
+	headersEntry.SetPlaceHolder("Optional, one \"Name: value\" per line")
+	headersEntry.SetMinRowsVisible(2)
+	headersEntry.Validator = func(text string) error {
+		_, err := parseHeaders(text)
+		return err
+	}
+
+	status := widget.NewLabel("")
+	status.Wrapping = fyne.TextWrapWord
+
+	form := &widget.Form{
+		Items: []*widget.FormItem{
+			widget.NewFormItem("Endpoint", endpointEntry),
+			widget.NewFormItem("Timeout (s)", timeoutEntry),
+			widget.NewFormItem("Bearer token", tokenEntry),
+			widget.NewFormItem("Headers", headersEntry),
+		},
+		SubmitText: "Save",
+	}
+
+	form.OnSubmit = func() {
+		// The entries were validated by the form, so parsing can not fail here
+		seconds, _ := strconv.Atoi(strings.TrimSpace(timeoutEntry.Text))
+		headers, _ := parseHeaders(headersEntry.Text)
+		connection := ollamaConnection{
+			Endpoint:    strings.TrimSpace(endpointEntry.Text),
+			Timeout:     time.Duration(seconds) * time.Second,
+			BearerToken: strings.TrimSpace(tokenEntry.Text),
+			Headers:     headers,
+		}
+
+		status.SetText("Checking connection...")
+		form.Disable()
+
+		// Check the connection in the background to keep the dialog responsive
+		go func() {
+			defer form.Enable()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := connection.check(ctx); err != nil {
+				status.SetText(fmt.Sprintf("Settings not saved: %v", err))
+				return
+			}
+
+			setOllamaConnection(connection)
+			saveOllamaConnection(prefs, connection)
+			status.SetText("Connected to Ollama. Settings saved.")
+		}()
+	}
+
+	return container.NewVBox(form, status)
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// recordWriter writes table rows as "Header: value" pairs, so that a retrieved
+// row can be understood on its own. Rows are grouped into sections of about
+// one chunk each, instead of writing a whole sheet as one blob of text.
+type recordWriter struct {
+	w      io.Writer
+	table  string   // Name of the table, e.g. the sheet name
+	header []string // Column names, taken from the first non-empty row
+
+	group     []string // Rows of the current group
+	groupSize int      // Characters in the current group
+	firstRow  int      // Row number of the first row in the group
+	lastRow   int      // Row number of the last row in the group
+}
+
+// writeRow adds a row of cells. The first non-empty row is used as the header.
+func (r *recordWriter) writeRow(number int, cells []string) error {
+	if strings.TrimSpace(strings.Join(cells, "")) == "" {
+		return nil
+	}
+
+	if r.header == nil {
+		r.header = make([]string, len(cells))
+		for i, cell := range cells {
+			r.header[i] = strings.TrimSpace(cell)
+		}
+		return nil
+	}
+
+	// Pair each value with its column name
+	var pairs []string
+	for i, cell := range cells {
+		cell = strings.Join(strings.Fields(cell), " ") // Keep each row on one line
+		if cell == "" {
+			continue
+		}
+
+		name := columnName(i)
+		if i < len(r.header) && r.header[i] != "" {
+			name = r.header[i]
+		}
+		pairs = append(pairs, name+": "+cell)
+	}
+	line := strings.Join(pairs, ", ")
+
+	// Start a new group when the row does not fit into the current one
+	if len(r.group) > 0 && r.groupSize+len(line)+1 > chunkSize {
+		if err := r.flush(); err != nil {
+			return err
+		}
+	}
+	if len(r.group) == 0 {
+		r.firstRow = number
+	}
+	r.group = append(r.group, line)
+	r.groupSize += len(line) + 1
+	r.lastRow = number
+	return nil
+}
+
+// flush writes the current group of rows as its own section.
+func (r *recordWriter) flush() error {
+	if len(r.group) == 0 {
+		return nil
+	}
+
+	location := fmt.Sprintf("rows %d-%d", r.firstRow, r.lastRow)
+	if r.table != "" {
+		location = r.table + ", " + location
+	}
+	startSection(r.w, location)
+
+	text := strings.Join(r.group, "\n") + "\n"
+	if r.table != "" {
+		text = "Sheet: " + r.table + "\n" + text
+	}
+	r.group, r.groupSize = nil, 0
+
+	if _, err := io.WriteString(r.w, text); err != nil {
+		return fmt.Errorf("failed to write rows: %w", err)
+	}
+	return nil
+}
+
+// columnName returns the spreadsheet name of a zero based column index, e.g. "AA" for 26.
+func columnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+// maxSpreadsheetColumns is the number of columns of a sheet, A to XFD.
+const maxSpreadsheetColumns = 16384
+
+// columnIndex returns the zero based column index of a cell reference such as
+// "AB12", or -1 for references beyond the last column.
+func columnIndex(reference string) int {
+	index := 0
+	for _, char := range strings.ToUpper(reference) {
+		if char < 'A' || char > 'Z' {
+			break
+		}
+		index = index*26 + int(char-'A'+1)
+		if index > maxSpreadsheetColumns {
+			return -1
+		}
+	}
+	return index - 1
+}
+
+// appendDelimitedFileContents appends the rows of a CSV or TSV file.
+func appendDelimitedFileContents(w io.Writer, filePath string, delimiter rune) error {
+	file, err := openTextFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	reader := csv.NewReader(file)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1 // Rows may have different numbers of fields
+	reader.LazyQuotes = true
+
+	records := &recordWriter{w: w}
+	for {
+		cells, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read delimited file %s: %w", filePath, err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		if err := records.writeRow(line, cells); err != nil {
+			return err
+		}
+	}
+
+	return records.flush()
+}
+
+// appendXlsxFileContents appends the rows of every sheet of an Excel (.xlsx) workbook.
+func appendXlsxFileContents(w io.Writer, filePath string) error {
+	archive, file, err := openZipFile(extractionContext(w), filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open Excel workbook %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	sharedStrings, err := readXlsxSharedStrings(archive)
+	if err != nil {
+		return fmt.Errorf("failed to read shared strings of %s: %w", filePath, err)
+	}
+
+	sheets, err := readXlsxSheets(archive)
+	if err != nil {
+		return fmt.Errorf("failed to read sheets of %s: %w", filePath, err)
+	}
+
+	for _, sheet := range sheets {
+		entry, err := openZipEntry(archive, sheet.part)
+		if err != nil {
+			return fmt.Errorf("failed to open sheet %s of %s: %w", sheet.name, filePath, err)
+		}
+
+		records := &recordWriter{w: w, table: sheet.name}
+		err = readXlsxRows(entry, sharedStrings, records.writeRow)
+		entry.Close()
+		if err == nil {
+			err = records.flush()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read sheet %s of %s: %w", sheet.name, filePath, err)
+		}
+	}
+
+	return nil
+}
+
+// xlsxSheet is a worksheet of a workbook and the archive part holding its cells.
+type xlsxSheet struct {
+	name string
+	part string
+}
+
+// readXlsxSheets returns the worksheets of a workbook in their display order.
+func readXlsxSheets(archive *zip.Reader) ([]xlsxSheet, error) {
+	entry, err := openZipEntry(archive, "xl/workbook.xml")
+	if err != nil {
+		return nil, err
+	}
+	defer entry.Close()
+
+	var workbook struct {
+		Sheets []struct {
+			Name string     `xml:"name,attr"`
+			Attr []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.NewDecoder(entry).Decode(&workbook); err != nil {
+		return nil, err
+	}
+
+	// The sheets refer to their parts through the workbook's relationships
+	rels, err := openZipEntry(archive, "xl/_rels/workbook.xml.rels")
+	if err != nil {
+		return nil, err
+	}
+	defer rels.Close()
+
+	var relationships struct {
+		Relationship []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		}
+	}
+	if err := xml.NewDecoder(rels).Decode(&relationships); err != nil {
+		return nil, err
+	}
+
+	targets := map[string]string{}
+	for _, rel := range relationships.Relationship {
+		target := rel.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		targets[rel.ID] = target
+	}
+
+	var sheets []xlsxSheet
+	for _, sheet := range workbook.Sheets {
+		for _, attr := range sheet.Attr {
+			if attr.Name.Local == "id" && targets[attr.Value] != "" {
+				sheets = append(sheets, xlsxSheet{name: sheet.Name, part: targets[attr.Value]})
+			}
+		}
+	}
+	return sheets, nil
+}
+
+// readXlsxSharedStrings returns the shared string table of a workbook.
+func readXlsxSharedStrings(archive *zip.Reader) ([]string, error) {
+	entry, err := openZipEntry(archive, "xl/sharedStrings.xml")
+	if err != nil {
+		return nil, nil // Workbooks without text cells have no shared strings
+	}
+	defer entry.Close()
+
+	var strs []string
+	var current strings.Builder
+	inText, skipDepth := false, 0
+
+	decoder := xml.NewDecoder(entry)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return strs, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			switch {
+			case token.Name.Local == "rPh" || skipDepth > 0:
+				skipDepth++ // Phonetic hints repeat the text in another script
+			case token.Name.Local == "si":
+				current.Reset()
+			case token.Name.Local == "t":
+				inText = true
+			}
+		case xml.EndElement:
+			switch {
+			case skipDepth > 0:
+				skipDepth--
+			case token.Name.Local == "si":
+				strs = append(strs, current.String())
+			case token.Name.Local == "t":
+				inText = false
+			}
+		case xml.CharData:
+			if inText && skipDepth == 0 {
+				current.Write(token)
+			}
+		}
+	}
+}
+
+// readXlsxRows reads the rows of a worksheet and passes the cell values of
+// each row to onRow, with empty strings for missing cells.
+func readXlsxRows(r io.Reader, sharedStrings []string, onRow func(number int, cells []string) error) error {
+	var (
+		cells     []string
+		rowNumber int
+		column    int
+		cellType  string
+		value     strings.Builder
+		inValue   bool
+	)
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			switch token.Name.Local {
+			case "row":
+				cells = nil
+				rowNumber++
+				if number, err := strconv.Atoi(xmlAttr(token, "r")); err == nil {
+					rowNumber = number
+				}
+			case "c":
+				column = len(cells)
+				if reference := xmlAttr(token, "r"); reference != "" {
+					column = columnIndex(reference)
+				}
+				if column >= maxSpreadsheetColumns {
+					// Cells beyond the last column are left out, rather than growing the row without bound
+					column = -1
+				}
+				cellType = xmlAttr(token, "t")
+				value.Reset()
+			case "v", "t":
+				inValue = true
+			}
+
+		case xml.EndElement:
+			switch token.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				text, err := xlsxCellText(value.String(), cellType, sharedStrings)
+				if err != nil {
+					return fmt.Errorf("row %d: %w", rowNumber, err)
+				}
+				for len(cells) <= column && column >= 0 {
+					cells = append(cells, "")
+				}
+				if column >= 0 {
+					cells[column] = text
+				}
+			case "row":
+				if err := onRow(rowNumber, cells); err != nil {
+					return err
+				}
+			}
+
+		case xml.CharData:
+			if inValue {
+				value.Write(token)
+			}
+		}
+	}
+}
+
+// xlsxCellText returns the text of a cell value of the given type.
+func xlsxCellText(value, cellType string, sharedStrings []string) (string, error) {
+	switch cellType {
+	case "s":
+		index, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || index < 0 || index >= len(sharedStrings) {
+			return "", errors.New("invalid shared string reference")
+		}
+		return sharedStrings[index], nil
+	case "b":
+		if strings.TrimSpace(value) == "1" {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	default:
+		return value, nil
+	}
+}
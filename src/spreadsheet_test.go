@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// extractSections returns the sections extracted from a file.
+func extractSections(t *testing.T, filePath string) []textSection {
+	t.Helper()
+	doc, err := extractFile(context.Background(), folderFile{Path: filePath, RelPath: filepath.Base(filePath)}, "", folderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc.Sections
+}
+
+func TestXlsxRowsAreWrittenAsRecords(t *testing.T) {
+	sections := extractSections(t, "testdata/readings.xlsx")
+
+	// Rows are grouped into sections of about one chunk, named after the sheet and rows
+	var locations []string
+	for _, section := range sections {
+		locations = append(locations, section.Location)
+		if !strings.HasPrefix(section.Text, "Sheet: Instruments\n") {
+			t.Errorf("section %q does not name its sheet: %q", section.Location, section.Text)
+		}
+		if len(section.Text) > chunkSize+len("Sheet: Instruments\n") {
+			t.Errorf("section %q has %d characters, more than a chunk", section.Location, len(section.Text))
+		}
+	}
+	want := []string{"Instruments, rows 2-19", "Instruments, rows 20-37", "Instruments, rows 38-55", "Instruments, rows 56-70"}
+	if !slices.Equal(locations, want) {
+		t.Fatalf("sections = %q, want %q", locations, want)
+	}
+
+	// Each value is named after its header, or its column letter without one.
+	// Rich text shared strings are joined, their phonetic reading left out.
+	if first := strings.Split(sections[0].Text, "\n")[1]; first != "Tag: PM3-FI-101, Range: 0-500 l/min, C: 2" {
+		t.Errorf("first row = %q", first)
+	}
+	if last := sections[3].Text; !strings.HasSuffix(last, "\nTag: inline, Range: TRUE\n") {
+		t.Errorf("last section = %q, want the inline string and boolean row", last)
+	}
+}
+
+func TestCsvRowsAreWrittenAsRecords(t *testing.T) {
+	sections := extractSections(t, "testdata/tags.csv")
+	want := []textSection{{
+		Location: "rows 2-3",
+		Text:     "Tag: PM3-FI-101, Range: 0-500 l/min\nTag: multi line, Range: x\n",
+	}}
+	if !slices.Equal(sections, want) {
+		t.Errorf("sections = %q, want %q", sections, want)
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	for reference, want := range map[string]int{
+		"A1":                          0,
+		"z9":                          25,
+		"AB12":                        27,
+		"XFD1":                        maxSpreadsheetColumns - 1,
+		"XFE1":                        -1,
+		"ZZZZZZZ1":                    -1,
+		strings.Repeat("Z", 40) + "1": -1,
+	} {
+		if got := columnIndex(reference); got != want {
+			t.Errorf("columnIndex(%q) = %d, want %d", reference, got, want)
+		}
+	}
+}
+
+func TestXlsxCellsBeyondTheLastColumnAreLeftOut(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "wide.xlsx")
+	writeZip(t, filePath, map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Wide" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="x/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+			`<row r="1"><c r="A1" t="inlineStr"><is><t>Tag</t></is></c><c r="ZZZZZZZ1" t="inlineStr"><is><t>Far</t></is></c></row>` +
+			`<row r="2"><c r="A2" t="inlineStr"><is><t>PM3-FI-101</t></is></c><c r="XFE2"><v>1</v></c></row>` +
+			`</sheetData></worksheet>`,
+	})
+
+	sections := extractSections(t, filePath)
+	want := []textSection{{Location: "Wide, rows 2-2", Text: "Sheet: Wide\nTag: PM3-FI-101\n"}}
+	if !slices.Equal(sections, want) {
+		t.Errorf("sections = %q, want %q", sections, want)
+	}
+}
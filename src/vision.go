@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+)
+
+// imageExtensions are the image formats described by the vision model.
+var imageExtensions = []string{".png", ".jpg", ".jpeg"}
+
+// visionPrompt asks the vision model for a description that can be found by
+// searching for the equipment, tags and values shown in the image.
+const visionPrompt = `Describe this image so that it can be found by a text search.
+First transcribe all visible text exactly, including labels, tag numbers, units and values.
+Then describe what the image shows, such as the type of diagram or photo, the equipment and how it is connected.
+Answer in plain text without any introduction.`
+
+var (
+	visionModelName  = "" // Images are not indexed until a vision model is chosen
+	visionModelMutex sync.RWMutex
+)
+
+// setVisionModelName sets the Ollama model that describes images when a
+// folder is indexed, e.g. "llava". An empty name leaves images out.
+func setVisionModelName(modelName string) {
+	visionModelMutex.Lock()
+	defer visionModelMutex.Unlock()
+	visionModelName = strings.TrimSpace(modelName)
+}
+
+// getVisionModelName returns the model that describes images, or "" if images are not indexed.
+func getVisionModelName() string {
+	visionModelMutex.RLock()
+	defer visionModelMutex.RUnlock()
+	return visionModelName
+}
+
+// isImageFile reports whether a file is an image described by the vision model.
+func isImageFile(filePath string) bool {
+	ext := strings.ToLower(path.Ext(filePath))
+	for _, imageExt := range imageExtensions {
+		if ext == imageExt {
+			return true
+		}
+	}
+	return false
+}
+
+// appendImageFileContents appends a description of an image, including the
+// text visible in it, written by the vision model.
+func appendImageFileContents(w io.Writer, filePath string) error {
+	file, err := openSourceFile(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	image, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("failed to read image %s: %w", filePath, err)
+	}
+
+	// The request is canceled with the extraction, e.g. when the file times out
+	description, err := describeImage(extractionContext(w), getVisionModelName(), image)
+	if err != nil {
+		return fmt.Errorf("failed to describe image %s: %w", filePath, err)
+	}
+
+	if _, err := io.WriteString(w, "Image: "+filepath.Base(filePath)+"\n"+description+"\n"); err != nil {
+		return fmt.Errorf("failed to write image description: %w", err)
+	}
+	return nil
+}
+
+// errVisionModel is returned when the vision model could not be asked, e.g.
+// when it is not pulled or Ollama is not running. It is not specific to the
+// image, so the image is not recorded as failed.
+var errVisionModel = errors.New("vision model")
+
+// describeImage asks the vision model to describe an image.
+func describeImage(ctx context.Context, model string, image []byte) (string, error) {
+	if model == "" {
+		return "", fmt.Errorf("%w: none selected", errVisionModel)
+	}
+
+	client, err := newOllamaClient()
+	if err != nil {
+		return "", fmt.Errorf("%w %s: %w", errVisionModel, model, err)
+	}
+
+	req := &api.GenerateRequest{
+		Model:  model,
+		Prompt: visionPrompt,
+		Images: []api.ImageData{image},
+		Options: map[string]interface{}{
+			"temperature": 0.1,
+		},
+		Stream: &FALSE,
+	}
+
+	var description strings.Builder
+	err = client.Generate(ctx, req, func(resp api.GenerateResponse) error {
+		description.WriteString(resp.Response)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w %s: %w", errVisionModel, model, err)
+	}
+
+	text := strings.TrimSpace(description.String())
+	if text == "" {
+		return "", fmt.Errorf("%s returned no description", model)
+	}
+	return text, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+// useFakeVision answers image descriptions with describe and embedding
+// requests with a fixed vector per text.
+func useFakeVision(t *testing.T, describe func(req api.GenerateRequest) (string, int)) {
+	t.Helper()
+	useFakeOllama(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/generate":
+			var req api.GenerateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			description, status := describe(req)
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				json.NewEncoder(w).Encode(map[string]string{"error": description})
+				return
+			}
+			json.NewEncoder(w).Encode(api.GenerateResponse{Model: req.Model, Response: description, Done: true})
+		case "/api/embed":
+			answerEmbeddings(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// useVisionModel sets the vision model for the duration of a test.
+func useVisionModel(t *testing.T, model string) {
+	t.Helper()
+	previous := getVisionModelName()
+	t.Cleanup(func() { setVisionModelName(previous) })
+	setVisionModelName(model)
+}
+
+func TestDescribeImage(t *testing.T) {
+	image := []byte("\x89PNG\r\n\x1a\nfake image")
+	useFakeVision(t, func(req api.GenerateRequest) (string, int) {
+		if req.Model != "llava" || req.Prompt != visionPrompt || len(req.Images) != 1 || !bytes.Equal(req.Images[0], image) {
+			return "unexpected request", http.StatusBadRequest
+		}
+		if req.Stream == nil || *req.Stream {
+			return "streamed request", http.StatusBadRequest
+		}
+		return "  Tag PT-101 reads 5 bar.\n", http.StatusOK
+	})
+
+	description, err := describeImage(context.Background(), "llava", image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if description != "Tag PT-101 reads 5 bar." {
+		t.Errorf("description = %q", description)
+	}
+}
+
+func TestDescribeImageErrors(t *testing.T) {
+	for name, test := range map[string]struct {
+		description string
+		status      int
+		want        string
+	}{
+		"empty response": {description: " \n", status: http.StatusOK, want: "llava returned no description"},
+		"server error":   {description: "model \"llava\" not found", status: http.StatusNotFound, want: "not found"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			useFakeVision(t, func(req api.GenerateRequest) (string, int) {
+				return test.description, test.status
+			})
+
+			description, err := describeImage(context.Background(), "llava", []byte("image"))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("description = %q, err = %v, want an error containing %q", description, err, test.want)
+			}
+		})
+	}
+
+	if _, err := describeImage(context.Background(), "", []byte("image")); err == nil {
+		t.Error("described an image without a vision model")
+	}
+}
+
+func TestAppendImageFileContents(t *testing.T) {
+	useVisionModel(t, "llava")
+	filePath := filepath.Join(t.TempDir(), "pump.png")
+	if err := os.WriteFile(filePath, []byte("\x89PNG\r\n\x1a\nfake image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	useFakeVision(t, func(req api.GenerateRequest) (string, int) {
+		return "Pump P-3 with its pressure gauge.", http.StatusOK
+	})
+	var text strings.Builder
+	if err := appendImageFileContents(&text, filePath); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Image: pump.png\nPump P-3 with its pressure gauge.\n"; text.String() != want {
+		t.Errorf("text = %q, want %q", text.String(), want)
+	}
+
+	useFakeVision(t, func(req api.GenerateRequest) (string, int) {
+		return "", http.StatusOK
+	})
+	if err := appendImageFileContents(&strings.Builder{}, filePath); err == nil || !strings.Contains(err.Error(), "pump.png") {
+		t.Errorf("err = %v, want the failure to name the image", err)
+	}
+}
+
+func TestVisionModelChangeDescribesImagesAgain(t *testing.T) {
+	useVisionModel(t, "llava")
+	useFakeVision(t, func(req api.GenerateRequest) (string, int) {
+		return "Described by " + req.Model, http.StatusOK
+	})
+
+	folder := writeTestFiles(t, map[string]string{
+		"notes.txt": "Felt tension is 4.5 kN/m.",
+		"pump.png":  "\x89PNG\r\n\x1a\nfake image",
+	})
+	index := &vectorIndex{Version: indexVersion, Folder: folder, EmbeddingModel: getEmbeddingModelName(), VisionModel: getVisionModelName()}
+	index, _, err := updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	description := func(index *vectorIndex) string {
+		for _, file := range index.Files {
+			if file.Path == "pump.png" {
+				return file.Chunks[0].Text
+			}
+		}
+		return ""
+	}
+	if got := description(index); !strings.Contains(got, "Described by llava") {
+		t.Fatalf("description = %q", got)
+	}
+
+	// Refreshing the index, e.g. when the folder changes, uses the new model
+	setVisionModelName("bakllava")
+	index, update, err := updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.VisionModel != "bakllava" || update.Changed != 1 || !update.changed() {
+		t.Errorf("vision model = %q, update = %+v", index.VisionModel, update)
+	}
+	if got := description(index); !strings.Contains(got, "Described by bakllava") {
+		t.Errorf("description = %q", got)
+	}
+
+	// Without a vision model images are left out
+	setVisionModelName("")
+	index, update, err = updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.VisionModel != "" || !update.changed() || !slices.Equal(indexedPaths(index), []string{"notes.txt"}) {
+		t.Errorf("vision model = %q, files = %q, update = %+v", index.VisionModel, indexedPaths(index), update)
+	}
+}
+
+func TestVisionModelFailureIsNotRecorded(t *testing.T) {
+	useVisionModel(t, "llava")
+	pulled := false
+	useFakeVision(t, func(req api.GenerateRequest) (string, int) {
+		if !pulled {
+			return "model \"llava\" not found, try pulling it first", http.StatusNotFound
+		}
+		return "Pump P-3 with its pressure gauge.", http.StatusOK
+	})
+
+	folder := writeTestFiles(t, map[string]string{
+		"notes.txt": "Felt tension is 4.5 kN/m.",
+		"pump.png":  "\x89PNG\r\n\x1a\nfake image",
+	})
+	index := &vectorIndex{Version: indexVersion, Folder: folder, EmbeddingModel: getEmbeddingModelName(), VisionModel: getVisionModelName()}
+
+	// The model is not specific to the image, so the update fails like it does when embedding fails
+	if _, _, err := updateIndex(context.Background(), index); !errors.Is(err, errVisionModel) {
+		t.Fatalf("err = %v, want the vision model error", err)
+	}
+
+	pulled = true
+	index, update, err := updateIndex(context.Background(), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.Skipped != 0 || !slices.Equal(indexedPaths(index), []string{"notes.txt", "pump.png"}) {
+		t.Errorf("files = %q, update = %+v", indexedPaths(index), update)
+	}
+}
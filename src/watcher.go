@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long the folder must stay quiet after a change before
+// it is re-indexed, so that saving or copying many files triggers one update.
+var watchDebounce = 2 * time.Second
+
+// folderWatcher re-indexes the selected folder in the background whenever
+// files in it change.
+type folderWatcher struct {
+	folder   string
+	watcher  *fsnotify.Watcher
+	onStatus func(status string)
+	debounce time.Duration
+
+	filterMutex sync.Mutex
+	filter      *pathFilter // Changes to ignored files do not trigger a re-index
+}
+
+var (
+	activeWatcher      *folderWatcher // Global variable to store the watcher of the selected folder
+	activeWatcherMutex sync.Mutex
+)
+
+// watchFolder starts watching the folder, replacing any folder watched before.
+// Status messages about background re-indexing are passed to onStatus.
+func watchFolder(folder string, onStatus func(status string)) error {
+	activeWatcherMutex.Lock()
+	defer activeWatcherMutex.Unlock()
+
+	if activeWatcher != nil {
+		activeWatcher.close()
+		activeWatcher = nil
+	}
+
+	watcher, err := newFolderWatcher(folder, onStatus)
+	if err != nil {
+		return err
+	}
+	activeWatcher = watcher
+	return nil
+}
+
+// stopWatching stops watching the selected folder, if it is being watched.
+func stopWatching() {
+	activeWatcherMutex.Lock()
+	defer activeWatcherMutex.Unlock()
+
+	if activeWatcher != nil {
+		activeWatcher.close()
+		activeWatcher = nil
+	}
+}
+
+// newFolderWatcher watches the folder and all its subdirectories.
+func newFolderWatcher(folder string, onStatus func(status string)) (*folderWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create folder watcher: %w", err)
+	}
+
+	fw := &folderWatcher{
+		folder:   folder,
+		watcher:  watcher,
+		onStatus: onStatus,
+		debounce: watchDebounce,
+	}
+	if err := fw.loadFilter(); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	// fsnotify does not watch recursively, so every subdirectory is added
+	if err := fw.addTree(folder); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	go fw.run()
+	fmt.Println("Watching folder:", folder)
+	return fw, nil
+}
+
+// loadFilter reads the ignore patterns of the folder, using the options the
+// folder's index was built with.
+func (fw *folderWatcher) loadFilter() error {
+	var options folderOptions
+	if index := getDocumentIndex(); index != nil && index.Folder == fw.folder {
+		options = index.Options
+	}
+
+	filter, err := newPathFilter(fw.folder, options)
+	if err != nil {
+		return err
+	}
+
+	fw.filterMutex.Lock()
+	defer fw.filterMutex.Unlock()
+	fw.filter = filter
+	return nil
+}
+
+// ignored reports whether a path in the folder is left out of the index.
+func (fw *folderWatcher) ignored(path string, isDir bool) bool {
+	relPath, err := filepath.Rel(fw.folder, path)
+	if err != nil || relPath == "." {
+		return false
+	}
+
+	fw.filterMutex.Lock()
+	defer fw.filterMutex.Unlock()
+	return fw.filter.ignored(filepath.ToSlash(relPath), isDir)
+}
+
+// addTree adds watches for a directory and all directories below it, except
+// for ignored directories such as .git or node_modules.
+func (fw *folderWatcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if fw.ignored(path, true) {
+			return filepath.SkipDir
+		}
+		if err := fw.watcher.Add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// close stops the watcher. A re-index that is already running still
+// finishes, but its result is only used if the index was not replaced.
+func (fw *folderWatcher) close() {
+	_ = fw.watcher.Close()
+}
+
+// run collects change events and re-indexes the folder once they settle down.
+func (fw *folderWatcher) run() {
+	// The timer only starts once the first change arrives
+	timer := time.NewTimer(fw.debounce)
+	timer.Stop()
+	changed := make(map[string]bool)
+
+	for {
+		select {
+		case event, ok := <-fw.watcher.Events:
+			if !ok {
+				return
+			}
+			if !fw.relevant(event) {
+				continue
+			}
+
+			// Watch new subdirectories as they are created
+			if event.Has(fsnotify.Create) {
+				if err := fw.addTree(event.Name); err != nil {
+					fmt.Println("Error watching new path:", err)
+				}
+			}
+
+			changed[event.Name] = true
+			timer.Reset(fw.debounce)
+
+		case err, ok := <-fw.watcher.Errors:
+			if !ok {
+				return
+			}
+			fmt.Println("Folder watcher error:", err)
+
+		case <-timer.C:
+			fw.reindex(len(changed))
+			clear(changed)
+		}
+	}
+}
+
+// relevant reports whether an event may change the contents of the index.
+func (fw *folderWatcher) relevant(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+
+	// The ignore file is hidden, but changing it changes which files are indexed
+	if event.Name == filepath.Join(fw.folder, ignoreFileName) {
+		return true
+	}
+	return !fw.ignored(event.Name, false)
+}
+
+// reindex brings the index of the watched folder up to date.
+func (fw *folderWatcher) reindex(changes int) {
+	index := getDocumentIndex()
+	if index == nil || index.Folder != fw.folder {
+		return
+	}
+
+	fw.onStatus(fmt.Sprintf("Re-indexing after changes to %d files...", changes))
+
+	// The ignore file may have changed as well
+	if err := fw.loadFilter(); err != nil {
+		fw.onStatus(fmt.Sprintf("Re-indexing failed: %v", err))
+		return
+	}
+
+	updated, update, err := refreshDocumentIndex(context.Background(), fw.folder)
+	if err != nil {
+		fw.onStatus(fmt.Sprintf("Re-indexing failed: %v", err))
+		return
+	}
+	if updated == nil {
+		// The folder was selected again or another folder was selected meanwhile
+		return
+	}
+	fw.onStatus(fmt.Sprintf("%s\nUpdated %s: %s", describeIndex(updated), time.Now().Format("15:04:05"), update))
+}
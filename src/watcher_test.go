@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchTestFolder opens the index of a folder and watches it, returning the
+// status messages of the watcher.
+func watchTestFolder(t *testing.T, folder string) <-chan string {
+	t.Helper()
+	useFakeEmbeddings(t)
+	useTempConfigDir(t)
+	t.Cleanup(func() { setDocumentIndex(nil) })
+	if _, _, err := openFolderIndex(context.Background(), folder, folderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := watchDebounce
+	watchDebounce = 200 * time.Millisecond
+	t.Cleanup(func() { watchDebounce = previous })
+
+	statuses := make(chan string, 10)
+	if err := watchFolder(folder, func(status string) { statuses <- status }); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stopWatching)
+	return statuses
+}
+
+func TestWatcherFiltersEvents(t *testing.T) {
+	folder := writeTestFiles(t, map[string]string{"notes.txt": "Felt tension is 4.5 kN/m."})
+	fw, err := newFolderWatcher(folder, func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fw.close()
+
+	for _, test := range []struct {
+		name string
+		op   fsnotify.Op
+		want bool
+	}{
+		{name: "notes.txt", op: fsnotify.Write, want: true},
+		{name: "docs/new.pdf", op: fsnotify.Create, want: true},
+		{name: "notes.txt", op: fsnotify.Chmod, want: false},
+		{name: ".git/index", op: fsnotify.Write, want: false},
+		{name: "~$report.docx", op: fsnotify.Create, want: false},
+		{name: ignoreFileName, op: fsnotify.Write, want: true},
+	} {
+		event := fsnotify.Event{Name: filepath.Join(folder, filepath.FromSlash(test.name)), Op: test.op}
+		if got := fw.relevant(event); got != test.want {
+			t.Errorf("relevant(%s %s) = %v, want %v", test.op, test.name, got, test.want)
+		}
+	}
+}
+
+func TestWatcherReindexesOnceChangesSettle(t *testing.T) {
+	folder := writeTestFiles(t, map[string]string{"notes.txt": "Felt tension is 4.5 kN/m."})
+	statuses := watchTestFolder(t, folder)
+
+	// Changes in quick succession lead to a single re-index, ignored files are not counted
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.tmp"} {
+		if err := os.WriteFile(filepath.Join(folder, name), []byte("Reading of "+name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(watchDebounce / 10)
+	}
+
+	var got []string
+	timeout := time.After(10 * watchDebounce)
+	for len(got) < 2 {
+		select {
+		case status := <-statuses:
+			got = append(got, status)
+		case <-timeout:
+			t.Fatalf("statuses = %q, want a re-index", got)
+		}
+	}
+	if got[0] != "Re-indexing after changes to 3 files..." || !strings.Contains(got[1], "3 added") {
+		t.Errorf("statuses = %q, want one re-index adding the 3 text files", got)
+	}
+	if paths := indexedPaths(getDocumentIndex()); !slices.Equal(paths, []string{"a.txt", "b.txt", "c.txt", "notes.txt"}) {
+		t.Errorf("indexed files = %q", paths)
+	}
+
+	// No further re-index follows once the folder is quiet
+	select {
+	case status := <-statuses:
+		t.Errorf("unexpected status %q", status)
+	case <-time.After(2 * watchDebounce):
+	}
+}